@@ -0,0 +1,240 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path"
+
+	"golang.org/x/image/draw"
+)
+
+/*
+zip cartridge - the manifest plus every emoji as its own png
+unlike the png atlas nothing is padded or guessed and any one tile can be read without decoding the rest
+*/
+const archiveManifest = "manifest.json"
+
+func archiveTile(i int) string {
+	return fmt.Sprintf("tiles/%d.png", i)
+}
+
+func (brand *Brand) CreateArchive(fileName string) error {
+
+	fmt.Printf("Saving zip cartridge %s -> %s\n", brand.name, fileName)
+
+	scalar, err := brand.GetScalar(1)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(fileName), 0700); err != nil {
+		return err
+	}
+
+	archiveName := fmt.Sprintf("%s-%dx%d.zip", fileName, scalar.Dx(), scalar.Dy())
+	out, err := os.Create(archiveName)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	archive := zip.NewWriter(out)
+
+	manifest := &CartridgeManifest{
+		Version:    cartridgeVersion,
+		Brand:      brand.name,
+		TileWidth:  scalar.Dx(),
+		TileHeight: scalar.Dy(),
+		Columns:    1, // tiles aren't on a grid, one per entry
+	}
+
+	var emojis []*Emoji
+	for _, emoji := range brand.emojis.list {
+
+		if emoji == nil {
+			continue
+		}
+		emojis = append(emojis, emoji)
+
+		// png is already deflated, no point doing it twice
+		entry, err := archive.CreateHeader(&zip.FileHeader{Name: archiveTile(len(manifest.Tiles)), Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(entry, Resize(emoji.img, scalar)); err != nil {
+			return err
+		}
+
+		manifest.Tiles = append(manifest.Tiles, NewManifestTile(emoji))
+	}
+
+	manifest.Count = len(manifest.Tiles)
+	manifest.Rows = manifest.Count
+
+	// zips aren't rewritten on load so the descriptors go in now - the ones the brand already has, and emojify's defaults if they're different
+	settings := brand.emojis.settings
+	manifest.AddDescriptors(NewDescriptorKey(scalar.Size(), settings), emojis)
+
+	defaults := Settings{backgroundColor: color.RGBA{A: 255}, imageScale: 1}
+	if NewDescriptorKey(scalar.Size(), defaults) != NewDescriptorKey(scalar.Size(), settings) {
+		var averaged []*Emoji
+		for _, emoji := range emojis {
+			averaged = append(averaged, &Emoji{average: CreateEmoji(emoji.name, emoji.img, defaults).average})
+		}
+		manifest.AddDescriptors(NewDescriptorKey(scalar.Size(), defaults), averaged)
+	}
+
+	entry, err := archive.Create(archiveManifest)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "\t")
+	if err := encoder.Encode(manifest); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// an open zip cartridge, tiles get decoded as they're asked for
+type ArchiveCartridge struct {
+	reader   *zip.ReadCloser
+	manifest *CartridgeManifest
+	entries  map[string]*zip.File
+}
+
+func OpenArchive(fileName string) (*ArchiveCartridge, error) {
+	reader, err := zip.OpenReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &ArchiveCartridge{reader: reader, entries: make(map[string]*zip.File)}
+	for _, f := range reader.File {
+		archive.entries[f.Name] = f
+	}
+
+	if archive.manifest, err = archive.readManifest(); err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("%s: %s", fileName, err)
+	}
+	return archive, nil
+}
+
+func (archive *ArchiveCartridge) readManifest() (*CartridgeManifest, error) {
+	f, ok := archive.entries[archiveManifest]
+	if !ok {
+		return nil, fmt.Errorf("zip cartridge has no %s", archiveManifest)
+	}
+
+	file, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	manifest := &CartridgeManifest{}
+	if err := json.NewDecoder(file).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("malformed manifest: %s", err)
+	}
+
+	if manifest.Version > cartridgeVersion {
+		return nil, fmt.Errorf("manifest is version %d but only up to %d is understood", manifest.Version, cartridgeVersion)
+	}
+	if len(manifest.Tiles) != manifest.Count {
+		return nil, fmt.Errorf("manifest lists %d tiles but says there are %d", len(manifest.Tiles), manifest.Count)
+	}
+	return manifest, nil
+}
+
+func (archive *ArchiveCartridge) Manifest() *CartridgeManifest {
+	return archive.manifest
+}
+
+func (archive *ArchiveCartridge) Count() int {
+	return archive.manifest.Count
+}
+
+// decodes tile i on its own, as it was saved (not scaled)
+func (archive *ArchiveCartridge) Tile(i int) (image.Image, error) {
+	if i < 0 || i >= archive.manifest.Count {
+		return nil, fmt.Errorf("tile %d out of range [0, %d)", i, archive.manifest.Count)
+	}
+
+	f, ok := archive.entries[archiveTile(i)]
+	if !ok {
+		return nil, fmt.Errorf("zip cartridge is missing tile %d", i)
+	}
+
+	file, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return png.Decode(file)
+}
+
+func (archive *ArchiveCartridge) Close() error {
+	return archive.reader.Close()
+}
+
+func ReadArchive(fileName string, brandName string, imageSettings Settings) (*Brand, error) {
+
+	archive, err := OpenArchive(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	manifest := archive.Manifest()
+	if len(brandName) == 0 {
+		brandName = manifest.Brand
+	}
+
+	emojiScalar, err := CreateScalar(image.Rectangle{Max: image.Point{manifest.TileWidth, manifest.TileHeight}}, imageSettings.imageScale)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Making emojikeg from %s\n", fileName)
+	brand := InitBrand(brandName, imageSettings)
+
+	// the zip isn't rewritten on load so only descriptors saved with it get used
+	descriptors := manifest.FindDescriptors(NewDescriptorKey(emojiScalar.Size(), imageSettings))
+
+	for i, tile := range manifest.Tiles {
+		img, err := archive.Tile(i)
+		if err != nil {
+			return nil, err
+		}
+
+		emoji := GetTransparent(imageSettings.backgroundColor, image.Rectangle{
+			image.Point{0, 0},
+			emojiScalar.Max,
+		})
+
+		draw.Draw(emoji, emojiScalar, Resize(img, emojiScalar), image.Point{0, 0}, draw.Over)
+
+		if descriptors == nil {
+			brand.emojis.Add(tile.Name, emoji, i).info = tile.Info()
+			continue
+		}
+
+		average, dominant, err := descriptors.Tile(i)
+		if err != nil {
+			return nil, err
+		}
+		brand.emojis.AddPrecomputed(tile.Name, emoji, i, average, dominant).info = tile.Info()
+	}
+
+	brand.emojis.Reindex()
+	return brand, nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// what gets emojified when there's no only: pattern
+var imageExtensions = []string{".png", ".jpg", ".jpeg", ".gif"}
+
+const defaultBatchOutput = "emojified"
+
+/*
+emojifying a folder (recursively) or a glob (photos/*.jpg) instead of one image
+only/skip are globs - with a / they're matched against the path inside the input, otherwise just the file name (skip leaves out whole folders too)
+*/
+type BatchSettings struct {
+	only, skip []string
+	jobs       int // images done at once, <= 0 => one per cpu
+}
+
+type batchResult struct {
+	input    string // relative to the input folder
+	output   string
+	err      error
+	duration time.Duration
+}
+
+// folders and globs (that don't happen to be an actual file) get emojified as a batch
+func IsBatchInput(path string) bool {
+	if folder, err := IsDir(path); err == nil {
+		return folder
+	}
+	return strings.ContainsAny(path, "*?[")
+}
+
+// the folder a glob starts from (photos/*/*.jpg => photos) so the output can mirror everything under it
+func globRoot(pattern string) string {
+	root := "."
+	for _, el := range strings.Split(filepath.ToSlash(filepath.Clean(pattern)), "/") {
+		if strings.ContainsAny(el, "*?[") {
+			break
+		}
+		root = filepath.Join(root, el)
+	}
+	if strings.HasPrefix(pattern, "/") {
+		root = "/" + root
+	}
+	return root
+}
+
+func matchesAny(patterns []string, relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	for _, pattern := range patterns {
+		target := filepath.Base(relPath)
+		if strings.Contains(pattern, "/") {
+			target = relPath
+		}
+		if matched, _ := filepath.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (settings *BatchSettings) Wants(relPath string) bool {
+	if matchesAny(settings.skip, relPath) {
+		return false
+	}
+	if len(settings.only) > 0 {
+		return matchesAny(settings.only, relPath)
+	}
+
+	ext := strings.ToLower(filepath.Ext(relPath))
+	for _, el := range imageExtensions {
+		if ext == el {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+every image the batch should emojify, relative to root (the folder, or where the glob starts)
+anything in outputPath is left out so running it twice doesn't emojify the last run's output
+*/
+func (settings *BatchSettings) FindImages(input string, outputPath string) (root string, images []string, err error) {
+
+	skipDir, _ := filepath.Abs(outputPath)
+
+	var paths []string
+	if folder, _ := IsDir(input); folder {
+		root, paths = input, []string{input}
+	} else {
+		root = globRoot(input)
+		if paths, err = filepath.Glob(input); err != nil {
+			return "", nil, err
+		}
+	}
+
+	for _, path := range paths {
+		err = filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+
+			if entry.IsDir() {
+				if abs, _ := filepath.Abs(path); abs == skipDir || (rel != "." && matchesAny(settings.skip, rel)) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if settings.Wants(rel) {
+				images = append(images, rel)
+			}
+			return nil
+		})
+
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	if len(images) == 0 {
+		return "", nil, fmt.Errorf("no images found in %s", input)
+	}
+	sort.Strings(images)
+	return root, images, nil
+}
+
+/*
+emojifies every image into outputPath, keeping the folders they were in
+the colour index is built once up front and then shared by the workers, a failed image doesn't stop the rest
+*/
+func (brand *Brand) EmojifyBatch(root string, images []string, outputPath string, batchSettings BatchSettings, convertSettings ConvertSettings, quality float64) []batchResult {
+
+	jobs := batchSettings.jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	if jobs > len(images) {
+		jobs = len(images)
+	}
+
+	fmt.Printf("Emojifying %d images from %s with brand %s (%d at once)\n", len(images), root, brand.name, jobs)
+	brand.Prepare(convertSettings)
+
+	results := make([]batchResult, len(images))
+	queue := make(chan int)
+
+	var done int
+	var mu sync.Mutex // for done and printing
+
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range queue {
+				start := time.Now()
+				result := batchResult{input: images[i]}
+
+				result.output, result.err = brand.emojifyInto(filepath.Join(root, images[i]), filepath.Join(outputPath, images[i]), convertSettings, quality)
+				result.duration = time.Since(start)
+				results[i] = result
+
+				mu.Lock()
+				done++
+				if result.err != nil {
+					fmt.Printf("[%d/%d] [error] %s: %s\n", done, len(images), result.input, result.err)
+				} else {
+					fmt.Printf("[%d/%d] %s -> %s (%s)\n", done, len(images), result.input, result.output, result.duration.Round(time.Millisecond))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := range images {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	return results
+}
+
+// outputName has the input's extension swapped for the one format (or quality) asks for, returns what it was saved as
+func (brand *Brand) emojifyInto(inputName string, outputName string, convertSettings ConvertSettings, quality float64) (string, error) {
+
+	if err := os.MkdirAll(filepath.Dir(outputName), 0700); err != nil {
+		return "", err
+	}
+
+	outputName = strings.TrimSuffix(outputName, filepath.Ext(outputName))
+	if len(convertSettings.metadata) > 0 {
+		convertSettings.metadata = outputName + ".json" // one next to each output
+	}
+
+	mosaic, err := brand.emojifyFile(inputName, convertSettings)
+	if err != nil {
+		return "", err
+	}
+
+	outputName += "." + imageFormat(convertSettings.format, quality)
+	return outputName, Export(outputName, mosaic.img, convertSettings.format, quality)
+}
+
+func summariseBatch(results []batchResult, elapsed time.Duration) error {
+
+	var failed []batchResult
+	var busy time.Duration // across all the workers
+	for _, result := range results {
+		busy += result.duration
+		if result.err != nil {
+			failed = append(failed, result)
+		}
+	}
+
+	succeeded := len(results) - len(failed)
+	fmt.Printf("\n%d/%d images emojified in %s", succeeded, len(results), elapsed.Round(time.Millisecond))
+	if len(results) > 0 {
+		fmt.Printf(" (%s per image on average)", (busy / time.Duration(len(results))).Round(time.Millisecond))
+	}
+	fmt.Printf("\n")
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	fmt.Printf("failed:\n")
+	for _, result := range failed {
+		fmt.Printf("\t%s: %s\n", result.input, result.err)
+	}
+	return fmt.Errorf("%d of %d images couldn't be emojified", len(failed), len(results))
+}
+
+// emojifies the input folder/glob with every brand given, into folders named after them if there's more than one
+func (dstSettings *DstSettings) runBatch(brands EmojiKeg, convertSettings ConvertSettings) error {
+
+	outputPath := dstSettings.outputImage
+	if len(outputPath) == 0 {
+		outputPath = defaultBatchOutput
+	}
+
+	root, images, err := dstSettings.batch.FindImages(dstSettings.inputImage, outputPath)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	var results []batchResult
+
+	for _, brand := range brands {
+		brandOutput := outputPath
+		if len(brands) > 1 {
+			brandOutput = filepath.Join(outputPath, brand.name)
+		}
+
+		for _, result := range brand.EmojifyBatch(root, images, brandOutput, dstSettings.batch, convertSettings, dstSettings.quality) {
+			if len(brands) > 1 {
+				result.input = fmt.Sprintf("%s (%s)", result.input, brand.name)
+			}
+			results = append(results, result)
+		}
+	}
+
+	return summariseBatch(results, time.Since(start))
+}
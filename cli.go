@@ -0,0 +1,452 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+/*
+emojiportal <command> [flags] [args]
+every command fills in the same SrcSettings/DstSettings the old % syntax does, so both end up running the same code
+*/
+type command struct {
+	name    string
+	args    string // shown in the usage line
+	summary string
+
+	// registers the command's flags and returns what to run once they've been parsed
+	setup func(fs *flag.FlagSet) func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"scrape", "", "download the unicode.org charts and save every brand as a cartridge (or a folder of images)", setupScrape},
+		{"export", "[folders/cartridges...]", "save folders, cartridges or the internal cartridge as cartridges (or folders of images)", setupExport},
+		{"emojify", "[input image/folder/glob, - for stdin] {output image/folder, - for stdout}", "turn an image (or every image in a folder) into a mosaic of emojis", setupEmojify("emojify")},
+		{"text", "[input image, - for stdin] {output text file}", "turn an image into lines of emoji characters, to stdout if there's no output", setupEmojify("text")},
+		{"inspect", "[folders/cartridges/html/internal...]", "list the brands that get loaded, or every skin tone variant of an emoji", setupInspect},
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		printCommands()
+		return errUsage
+	}
+
+	for _, el := range args {
+		if el == seperator {
+			return runLegacy(args)
+		}
+	}
+
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		if len(args) > 1 {
+			if cmd := findCommand(args[1]); cmd != nil {
+				return cmd.Run([]string{"-h"})
+			}
+			return fmt.Errorf("unknown command %s", args[1])
+		}
+		printCommands()
+		return nil
+	}
+
+	if cmd := findCommand(args[0]); cmd != nil {
+		return cmd.Run(args[1:])
+	}
+	return runLegacy(args)
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func printCommands() {
+	fmt.Printf("usage: emojiportal <command> [flags] [args]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Printf("  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Printf("\nemojiportal help <command> shows a command's flags\nthe old [src...] %% [dst...] syntax still works\n")
+}
+
+func (cmd *command) Run(args []string) error {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: emojiportal %s [flags] %s\n\n%s\n\nflags:\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+
+	runner := cmd.setup(fs)
+
+	positional, err := parseInterleaved(fs, args)
+	if err == flag.ErrHelp {
+		return err
+	} else if err != nil {
+		return errUsage // flag has already said what was wrong
+	}
+	return runner(positional)
+}
+
+// flags can come before, between or after the other arguments
+func parseInterleaved(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// for flags that can be given more than once
+type listFlag []string
+
+func (list *listFlag) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *listFlag) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+type sourceOptions struct {
+	brands, exclude string
+	escale          float64
+
+	html        ScrapeSource
+	charts      listFlag
+	noModifiers bool
+	cache       string
+	strict      int
+}
+
+// html => also the flags that control scraping
+func addSourceFlags(fs *flag.FlagSet, html bool) *sourceOptions {
+	options := &sourceOptions{html: DefaultScrapeSource()}
+
+	fs.StringVar(&options.brands, "brands", "", "only load these brands, seperated by commas (unicode.org's names like Goog work too)")
+	fs.StringVar(&options.exclude, "exclude", "", "don't load these brands, seperated by commas")
+	fs.Float64Var(&options.escale, "escale", 1, "scale every emoji by this much as it's loaded, in (0,1]")
+
+	if !html {
+		return options
+	}
+
+	fs.BoolVar(&options.noModifiers, "no-modifiers", false, "leave out full-emoji-modifiers.html (skin tones)")
+	fs.Var(&options.charts, "charts", "saved chart page, or folder of them, to use instead of downloading (repeatable)")
+	fs.StringVar(&options.html.chartsURL, "url", defaultChartsURL, "base url of the chart pages, e.g. "+defaultChartsURL+"-15.0 to pin a release")
+	fs.StringVar(&options.html.emojiTest, "emoji-test", "", "url or path of emoji-test.txt (default a saved one next to -charts, or the one from the same release as -url when downloading the charts)")
+	fs.DurationVar(&options.html.timeout, "timeout", defaultTimeout, "give up on a download attempt after this long")
+	fs.IntVar(&options.html.retries, "retries", defaultRetries, "how many times to retry a failed download")
+	fs.StringVar(&options.cache, "cache", options.html.cacheDir, "where downloaded pages are cached, off => don't")
+	fs.IntVar(&options.strict, "strict", -1, "fail if more than this many rows couldn't be scraped, -1 => never")
+	return options
+}
+
+// sources are folders, cartridges, internal or html - none => internal
+func (options *sourceOptions) Settings(sources []string) (*SrcSettings, error) {
+	if options.escale <= 0 || options.escale > 1 {
+		return nil, fmt.Errorf("-escale has to be in (0,1]")
+	}
+
+	settings := &SrcSettings{html: options.html}
+
+	if len(options.brands) > 0 || len(options.exclude) > 0 {
+		settings.filter = &BrandFilter{}
+		if len(options.brands) > 0 {
+			settings.filter.include = strings.Split(options.brands, ",")
+		}
+		if len(options.exclude) > 0 {
+			settings.filter.exclude = strings.Split(options.exclude, ",")
+		}
+	}
+
+	settings.html.files = options.charts
+	settings.html.modifiers = !options.noModifiers
+	if settings.html.cacheDir = options.cache; options.cache == "off" {
+		settings.html.cacheDir = ""
+	}
+	if options.strict >= 0 {
+		settings.html.strict, settings.html.maxErrors = true, options.strict
+	}
+
+	if len(sources) == 0 {
+		sources = []string{"internal"}
+	}
+
+	if sources[0] == "internal" || sources[0] == "html" {
+		if len(sources) > 1 {
+			return nil, fmt.Errorf("%s can't be combined with other sources", sources[0])
+		}
+		settings.mode = sources[0]
+		return settings, nil
+	}
+
+	_, _, missingPaths := LoopPathList(sources)
+	if len(missingPaths) > 0 {
+		return nil, fmt.Errorf("source %s doesn't exist", missingPaths[0])
+	}
+	settings.paths = sources
+	return settings, nil
+}
+
+type saveOptions struct {
+	out, as, format string
+	columns         int
+}
+
+func addSaveFlags(fs *flag.FlagSet) *saveOptions {
+	options := &saveOptions{}
+
+	fs.StringVar(&options.out, "out", "", "folder to save to (default cartridges, or emojis with -as list)")
+	fs.StringVar(&options.as, "as", "cart", "cart => one cartridge per brand, list => a folder of images per brand")
+	fs.StringVar(&options.format, "format", "png", "cartridge format - png or zip")
+	fs.IntVar(&options.columns, "cols", 0, "emojis per row in png cartridges (default as square as possible)")
+	return options
+}
+
+func (options *saveOptions) Settings(escale float64) (*DstSettings, error) {
+	settings := &DstSettings{mode: options.as, pathName: options.out, format: options.format, columns: options.columns, escale: escale, iscale: 1, quality: 1}
+
+	switch options.as {
+	case "cart":
+		if len(settings.pathName) == 0 {
+			settings.pathName = "cartridges"
+		}
+	case "list":
+		if len(settings.pathName) == 0 {
+			settings.pathName = "emojis"
+		}
+	default:
+		return nil, fmt.Errorf("-as has to be cart or list, not %s", options.as)
+	}
+
+	if options.format != "png" && options.format != "zip" {
+		return nil, fmt.Errorf("-format has to be png or zip, not %s", options.format)
+	}
+	if options.columns < 0 {
+		return nil, fmt.Errorf("-cols can't be negative")
+	}
+	return settings, nil
+}
+
+func setupScrape(fs *flag.FlagSet) func(args []string) error {
+	source := addSourceFlags(fs, true)
+	save := addSaveFlags(fs)
+
+	return func(args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("unexpected argument %s, scrape only takes flags", args[0])
+		}
+		return load(source, []string{"html"}, save)
+	}
+}
+
+func setupExport(fs *flag.FlagSet) func(args []string) error {
+	source := addSourceFlags(fs, false)
+	save := addSaveFlags(fs)
+
+	return func(args []string) error {
+		for _, el := range args {
+			if el == "html" {
+				return fmt.Errorf("use scrape to save emojis from unicode.org")
+			}
+		}
+		return load(source, args, save)
+	}
+}
+
+func load(source *sourceOptions, sources []string, save *saveOptions) error {
+	srcSettings, err := source.Settings(sources)
+	if err != nil {
+		return err
+	}
+	dstSettings, err := save.Settings(source.escale)
+	if err != nil {
+		return err
+	}
+
+	emojis, err := srcSettings.Load(dstSettings.ImageSettings())
+	if err != nil {
+		return err
+	}
+	return dstSettings.Run(emojis)
+}
+
+func setupEmojify(mode string) func(fs *flag.FlagSet) func(args []string) error {
+	return func(fs *flag.FlagSet) func(args []string) error {
+		var sources listFlag
+		fs.Var(&sources, "src", "folder, cartridge, html or internal to take emojis from (repeatable, default internal)")
+		source := addSourceFlags(fs, true)
+
+		settings := &DstSettings{mode: mode}
+		var metric, dither, average, layout, mix, tones, only, skip string
+
+		fs.Float64Var(&settings.iscale, "iscale", 1, "scale the input image by this much first, in (0,1]")
+		fs.StringVar(&metric, "metric", "rgb", "how colours are compared - rgb, redmean, cie76 or ciede2000 (exact but checks every emoji, slowest)")
+		fs.IntVar(&settings.grid, "grid", 0, "match each emoji on an NxN grid of colours instead of its average")
+		fs.StringVar(&dither, "dither", "none", "none, fs, atkinson or bayer")
+		fs.StringVar(&average, "average", "linear", "how emojis are boiled down to a colour - linear, alpha or legacy")
+		fs.StringVar(&settings.match, "match", "average", "average or dominant (colours)")
+		fs.IntVar(&settings.dominantColors, "colors", 0, "dominant colours per emoji with -match dominant (default 4)")
+		fs.Float64Var(&settings.penalty, "penalty", 0, "added to an emoji's distance for every time it's been used within -radius")
+		fs.IntVar(&settings.radius, "radius", 0, "cells around each one -penalty looks at (default 1)")
+		fs.IntVar(&settings.maxUse, "maxuse", 0, "most times any one emoji can be placed, 0 => no limit")
+		fs.IntVar(&settings.topN, "topn", 0, "pick randomly between the N closest emojis")
+		fs.Int64Var(&settings.seed, "seed", 0, "seed for anything random, same seed => same output (default from the clock)")
+		fs.StringVar(&layout, "layout", "grid", "grid or quadtree")
+		fs.IntVar(&settings.maxTile, "maxtile", 0, "biggest quadtree tile in cells (default 8)")
+		fs.Float64Var(&settings.threshold, "threshold", 0, "colour deviation a quadtree block can have before it's split (default 12)")
+		fs.StringVar(&settings.brand, "brand", "", "brand to use when more than one is loaded - name, index (from 1) or all for one output each (emojify only)")
+		fs.StringVar(&mix, "mix", "", "pool brands into one mosaic - all or brand=weight,...")
+		fs.StringVar(&tones, "tones", "all", "skin tones to use - all, or any of base, light, medium-light, medium, medium-dark, dark seperated by commas")
+		fs.StringVar(&settings.metadata, "meta", "", "also write which emoji went in every tile to this json file")
+
+		if mode == "text" {
+			fs.IntVar(&settings.columns, "cols", 0, "max emojis per line")
+		} else {
+			fs.Float64Var(&settings.quality, "quality", 1, "jpeg quality (0,1], 1 => png")
+			fs.StringVar(&settings.format, "format", "", "png or jpg - for when the output is - (stdout) or has no extension (default from -quality)")
+			fs.StringVar(&only, "only", "", "with a folder/glob input, only emojify files matching these globs, seperated by commas (default any png/jpg/gif)")
+			fs.StringVar(&skip, "skip", "", "with a folder/glob input, leave out files matching these globs, seperated by commas")
+			fs.IntVar(&settings.batch.jobs, "jobs", 0, "with a folder/glob input, how many images to emojify at once (default one per cpu)")
+		}
+
+		return func(args []string) error {
+			if len(args) == 0 || len(args) > 2 {
+				return fmt.Errorf("%s takes an input image and at most one output, got %d arguments", mode, len(args))
+			}
+
+			if IsBatchInput(args[0]) {
+				if mode == "text" {
+					return fmt.Errorf("text takes one input image, use emojify for a folder or glob")
+				}
+				if len(args) == 2 && args[1] == stdio {
+					return fmt.Errorf("a folder or glob makes more than one image, they can't all go to stdout")
+				}
+			} else if _, err := IsDir(args[0]); err != nil && args[0] != stdio {
+				return fmt.Errorf("input image %s doesn't exist", args[0])
+			}
+			settings.inputImage = args[0]
+			if len(only) > 0 {
+				settings.batch.only = strings.Split(only, ",")
+			}
+			if len(skip) > 0 {
+				settings.batch.skip = strings.Split(skip, ",")
+			}
+			if len(args) == 2 {
+				settings.outputImage = args[1]
+			}
+
+			var err error
+			if settings.metric, err = ParseMetric(metric); err != nil {
+				return fmt.Errorf("-metric: %s", err)
+			}
+			if settings.dither, err = ParseDither(dither); err != nil {
+				return fmt.Errorf("-dither: %s", err)
+			}
+			if settings.averaging, err = ParseAverage(average); err != nil {
+				return fmt.Errorf("-average: %s", err)
+			}
+			if settings.tones, err = ParseToneSelection(tones); err != nil {
+				return fmt.Errorf("-tones: %s", err)
+			}
+			if len(mix) > 0 {
+				if settings.mix, err = parseMix(mix); err != nil {
+					return fmt.Errorf("-mix: %s", err)
+				}
+			}
+
+			if settings.match != "average" && settings.match != "dominant" {
+				return fmt.Errorf("-match has to be average or dominant, not %s", settings.match)
+			}
+			if layout != "grid" && layout != "quadtree" {
+				return fmt.Errorf("-layout has to be grid or quadtree, not %s", layout)
+			}
+			settings.quadtree = layout == "quadtree"
+
+			for name, value := range map[string]int{"grid": settings.grid, "colors": settings.dominantColors, "radius": settings.radius, "maxuse": settings.maxUse, "topn": settings.topN, "maxtile": settings.maxTile, "cols": settings.columns, "jobs": settings.batch.jobs} {
+				if value < 0 {
+					return fmt.Errorf("-%s can't be negative", name)
+				}
+			}
+			if settings.iscale <= 0 || settings.iscale > 1 {
+				return fmt.Errorf("-iscale has to be in (0,1]")
+			}
+			if mode == "emojify" && (settings.quality <= 0 || settings.quality > 1) {
+				return fmt.Errorf("-quality has to be in (0,1]")
+			}
+			if len(settings.format) > 0 && settings.format != "png" && settings.format != "jpg" {
+				return fmt.Errorf("-format has to be png or jpg, not %s", settings.format)
+			}
+			if settings.penalty < 0 || settings.threshold < 0 {
+				return fmt.Errorf("-penalty and -threshold can't be negative")
+			}
+
+			fs.Visit(func(f *flag.Flag) {
+				if f.Name == "seed" {
+					settings.seeded = true
+				}
+			})
+
+			srcSettings, err := source.Settings(sources)
+			if err != nil {
+				return err
+			}
+			settings.escale = source.escale
+			settings.ClaimStdout()
+
+			emojis, err := srcSettings.Load(settings.ImageSettings())
+			if err != nil {
+				return err
+			}
+			return settings.Run(emojis)
+		}
+	}
+}
+
+func setupInspect(fs *flag.FlagSet) func(args []string) error {
+	source := addSourceFlags(fs, true)
+
+	var variants string
+	fs.StringVar(&variants, "variants", "", "list every skin tone variant of this emoji - as itself, U+1F44B or 1f44b")
+
+	return func(args []string) error {
+		srcSettings, err := source.Settings(args)
+		if err != nil {
+			return err
+		}
+
+		settings := &DstSettings{mode: "variants", escale: source.escale, iscale: 1, quality: 1}
+		if len(variants) > 0 {
+			if settings.code, err = parseEmojiArg(variants); err != nil {
+				return fmt.Errorf("-variants: %s", err)
+			}
+		}
+
+		emojis, err := srcSettings.Load(settings.ImageSettings())
+		if err != nil {
+			return err
+		}
+
+		if len(settings.code) == 0 {
+			fmt.Printf("\n%s", emojis)
+			return nil
+		}
+		return settings.Run(emojis)
+	}
+}
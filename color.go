@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// how "far apart" two colours are - plain RGB distance is what color.Palette uses but it's awful for skin tones and dark blues
+type ColorMetric int
+
+const (
+	MetricRGB       ColorMetric = iota // squared euclidean distance in sRGB (same as color.Palette.Index)
+	MetricRedmean                      // weighted euclidean, cheap approximation of perception
+	MetricCIE76                        // ΔE*76, euclidean distance in CIELAB
+	MetricCIEDE2000                    // ΔE*00, the proper (and slowest) one
+)
+
+var metricNames = map[string]ColorMetric{
+	"rgb":       MetricRGB,
+	"redmean":   MetricRedmean,
+	"cie76":     MetricCIE76,
+	"ciede2000": MetricCIEDE2000,
+}
+
+func ParseMetric(name string) (ColorMetric, error) {
+	if metric, exists := metricNames[strings.ToLower(name)]; exists {
+		return metric, nil
+	}
+	return MetricRGB, fmt.Errorf("unknown colour metric %s (rgb/redmean/cie76/ciede2000)", name)
+}
+
+func (metric ColorMetric) String() string {
+	for name, el := range metricNames {
+		if el == metric {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// converts an 8-bit sRGB colour into the space the metric measures distances in
+func (metric ColorMetric) Project(r, g, b float64) [3]float64 {
+	switch metric {
+	case MetricCIE76, MetricCIEDE2000:
+		return RGBToLab(r, g, b)
+	default:
+		return [3]float64{r, g, b}
+	}
+}
+
+func (metric ColorMetric) ProjectColor(col color.Color) [3]float64 {
+	basic := ColorToBasic(col)
+	return metric.Project(float64(basic[0]), float64(basic[1]), float64(basic[2]))
+}
+
+// squared distance between two points that have already been projected with the same metric
+func (metric ColorMetric) Distance(p, q [3]float64) float64 {
+	switch metric {
+	case MetricRedmean:
+		rMean := (p[0] + q[0]) / 2
+		dR, dG, dB := p[0]-q[0], p[1]-q[1], p[2]-q[2]
+		return (2+rMean/256)*dR*dR + 4*dG*dG + (2+(255-rMean)/256)*dB*dB
+
+	case MetricCIEDE2000:
+		dE := CIEDE2000(p, q)
+		return dE * dE
+
+	default: // rgb and cie76 are both plain euclidean, just in different spaces
+		dX, dY, dZ := p[0]-q[0], p[1]-q[1], p[2]-q[2]
+		return dX*dX + dY*dY + dZ*dZ
+	}
+}
+
+// a descriptor is several projected colours back to back, their distances simply add up
+func (metric ColorMetric) DescriptorDistance(p, q []float64) float64 {
+	var total float64
+	for i := 0; i+2 < len(p); i += 3 {
+		total += metric.Distance([3]float64{p[i], p[i+1], p[i+2]}, [3]float64{q[i], q[i+1], q[i+2]})
+	}
+	return total
+}
+
+// smallest possible distance between two descriptors that differ by diff along one axis - lets the k-d tree skip branches
+// ciede2000 doesn't have a neat bound, the tree scans every point for it instead
+func (metric ColorMetric) AxisBound(axis int, diff float64) float64 {
+	if metric == MetricRedmean {
+		weights := [3]float64{2, 4, 2} // lowest the redmean weights can go for colours in [0, 255]
+		return weights[axis%3] * diff * diff
+	}
+	return diff * diff
+}
+
+// how an emoji's pixels are boiled down to one colour
+type AverageMode int
+
+const (
+	AverageLinear AverageMode = iota // composited onto the background the way the mosaic is drawn, then averaged in linear light - what you actually see
+	AverageAlpha                     // alpha weighted and composited but straight in sRGB
+	AverageLegacy                    // opaque-ish pixels summed in sRGB over the whole area, how it used to be
+)
+
+var averageNames = map[string]AverageMode{
+	"linear": AverageLinear,
+	"alpha":  AverageAlpha,
+	"legacy": AverageLegacy,
+}
+
+func ParseAverage(name string) (AverageMode, error) {
+	if mode, exists := averageNames[strings.ToLower(name)]; exists {
+		return mode, nil
+	}
+	return AverageLinear, fmt.Errorf("unknown averaging %s (linear/alpha/legacy)", name)
+}
+
+// 8-bit sRGB -> linear light [0, 1]
+func linearise(c float64) float64 {
+	c /= 255
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+// linear light [0, 1] -> 8-bit sRGB
+func delinearise(c float64) float64 {
+	if c <= 0.0031308 {
+		return c * 12.92 * 255
+	}
+	return (1.055*math.Pow(c, 1/2.4) - 0.055) * 255
+}
+
+// sRGB (0-255) -> CIELAB under D65
+func RGBToLab(r, g, b float64) [3]float64 {
+	r, g, b = linearise(r), linearise(g), linearise(b)
+
+	// XYZ, normalised by the D65 white point
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*b) / 0.95047
+	y := (0.2126729*r + 0.7151522*g + 0.0721750*b) / 1.00000
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*b) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389.0 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27.0*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+
+	return [3]float64{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+// CIELAB (D65) -> 8-bit sRGB, clamped
+func LabToRGB(lab [3]float64) (r, g, b uint8) {
+	fy := (lab[0] + 16) / 116
+	fx := fy + lab[1]/500
+	fz := fy - lab[2]/200
+
+	f := func(t float64) float64 {
+		if t*t*t > 216.0/24389.0 {
+			return t * t * t
+		}
+		return (116*t - 16) / (24389.0 / 27.0)
+	}
+	x, y, z := f(fx)*0.95047, f(fy), f(fz)*1.08883
+
+	toByte := func(c float64) uint8 {
+		return uint8(math.Round(math.Max(0, math.Min(255, delinearise(math.Max(0, c))))))
+	}
+
+	return toByte(3.2404542*x - 1.5371385*y - 0.4985314*z),
+		toByte(-0.9692660*x + 1.8760108*y + 0.0415560*z),
+		toByte(0.0556434*x - 0.2040259*y + 1.0572252*z)
+}
+
+// straight from Sharma et al. (2005), with kL = kC = kH = 1
+func CIEDE2000(lab1, lab2 [3]float64) float64 {
+
+	deg := math.Pi / 180
+	pow7 := func(x float64) float64 { return x * x * x * x * x * x * x }
+
+	l1, a1, b1 := lab1[0], lab1[1], lab1[2]
+	l2, a2, b2 := lab2[0], lab2[1], lab2[2]
+
+	cBar := (math.Hypot(a1, b1) + math.Hypot(a2, b2)) / 2
+	g := 0.5 * (1 - math.Sqrt(pow7(cBar)/(pow7(cBar)+pow7(25))))
+
+	a1p, a2p := (1+g)*a1, (1+g)*a2
+	c1p, c2p := math.Hypot(a1p, b1), math.Hypot(a2p, b2)
+
+	hue := func(b, a float64) float64 {
+		if a == 0 && b == 0 {
+			return 0
+		}
+		h := math.Atan2(b, a) / deg
+		if h < 0 {
+			h += 360
+		}
+		return h
+	}
+	h1p, h2p := hue(b1, a1p), hue(b2, a2p)
+
+	dLp := l2 - l1
+	dCp := c2p - c1p
+
+	var dhp float64
+	if c1p*c2p != 0 {
+		dhp = h2p - h1p
+		if dhp > 180 {
+			dhp -= 360
+		} else if dhp < -180 {
+			dhp += 360
+		}
+	}
+	dHp := 2 * math.Sqrt(c1p*c2p) * math.Sin(dhp/2*deg)
+
+	lBarp := (l1 + l2) / 2
+	cBarp := (c1p + c2p) / 2
+
+	hBarp := h1p + h2p
+	if c1p*c2p != 0 {
+		if math.Abs(h1p-h2p) <= 180 {
+			hBarp /= 2
+		} else if h1p+h2p < 360 {
+			hBarp = (hBarp + 360) / 2
+		} else {
+			hBarp = (hBarp - 360) / 2
+		}
+	}
+
+	t := 1 - 0.17*math.Cos((hBarp-30)*deg) + 0.24*math.Cos(2*hBarp*deg) +
+		0.32*math.Cos((3*hBarp+6)*deg) - 0.20*math.Cos((4*hBarp-63)*deg)
+
+	dTheta := 30 * math.Exp(-math.Pow((hBarp-275)/25, 2))
+	rC := 2 * math.Sqrt(pow7(cBarp)/(pow7(cBarp)+pow7(25)))
+
+	sL := 1 + (0.015*math.Pow(lBarp-50, 2))/math.Sqrt(20+math.Pow(lBarp-50, 2))
+	sC := 1 + 0.045*cBarp
+	sH := 1 + 0.015*cBarp*t
+	rT := -math.Sin(2*dTheta*deg) * rC
+
+	return math.Sqrt(math.Pow(dLp/sL, 2) + math.Pow(dCp/sC, 2) + math.Pow(dHp/sH, 2) +
+		rT*(dCp/sC)*(dHp/sH))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// spreads the difference between a source pixel and the chosen emoji's colour onto the cells around it
+type DitherMode int
+
+const (
+	DitherNone DitherMode = iota
+	DitherFloydSteinberg
+	DitherAtkinson
+	DitherBayer // ordered, nothing is propagated - each cell gets nudged by a fixed threshold map instead
+)
+
+var ditherNames = map[string]DitherMode{
+	"none":     DitherNone,
+	"fs":       DitherFloydSteinberg,
+	"floyd":    DitherFloydSteinberg,
+	"atkinson": DitherAtkinson,
+	"bayer":    DitherBayer,
+}
+
+func ParseDither(name string) (DitherMode, error) {
+	if mode, exists := ditherNames[strings.ToLower(name)]; exists {
+		return mode, nil
+	}
+	return DitherNone, fmt.Errorf("unknown dither mode %s (none/fs/atkinson/bayer)", name)
+}
+
+type ditherWeight struct {
+	dx, dy int
+	weight float64
+}
+
+var ditherKernels = map[DitherMode][]ditherWeight{
+	DitherFloydSteinberg: {
+		{1, 0, 7.0 / 16},
+		{-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16}, {1, 1, 1.0 / 16},
+	},
+	DitherAtkinson: { // only passes on 6/8 of the error, keeps contrast high
+		{1, 0, 1.0 / 8}, {2, 0, 1.0 / 8},
+		{-1, 1, 1.0 / 8}, {0, 1, 1.0 / 8}, {1, 1, 1.0 / 8},
+		{0, 2, 1.0 / 8},
+	},
+}
+
+var bayerMatrix = [4][4]float64{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// how far (in 8-bit sRGB) the bayer threshold can push a cell either way - roughly the gap between neighbouring emoji averages
+const bayerSpread = 48
+
+// nil => error isn't diffused
+func (mode DitherMode) Kernel() []ditherWeight {
+	return ditherKernels[mode]
+}
+
+// fixed nudge for the cell at x, y - only non zero for ordered dithering
+func (mode DitherMode) Offset(x, y int) float64 {
+	if mode != DitherBayer {
+		return 0
+	}
+	return ((bayerMatrix[y%4][x%4]+0.5)/16 - 0.5) * bayerSpread
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// how many emojis get looked at per cell when diversity is turned on (topN raises this if it's bigger)
+const diversityCandidates = 16
+
+/*
+stops big flat areas from turning into a wall of the same emoji
+- penalty: added to an emoji's (colour) distance for every time it's already been used within radius cells
+- maxUse: hard cap on how many times one emoji can be placed, ignored if nothing else is close enough
+- topN: pick randomly between the N best scoring emojis rather than always the best
+*/
+type diversity struct {
+	penalty float64
+	radius  int
+	maxUse  int
+	topN    int
+
+	columns int
+	chosen  []*Emoji // every cell placed so far, row by row
+	usage   map[*Emoji]int
+}
+
+// nil => diversity isn't turned on and the usual one-emoji-per-colour behaviour applies
+func newDiversity(convertSettings ConvertSettings, columns, rows int) *diversity {
+	if convertSettings.penalty <= 0 && convertSettings.maxUse <= 0 && convertSettings.topN <= 1 {
+		return nil
+	}
+
+	diverse := &diversity{
+		penalty: convertSettings.penalty,
+		radius:  convertSettings.radius,
+		maxUse:  convertSettings.maxUse,
+		topN:    convertSettings.topN,
+		columns: columns,
+		chosen:  make([]*Emoji, columns*rows),
+		usage:   make(map[*Emoji]int),
+	}
+
+	if diverse.radius <= 0 {
+		diverse.radius = 1
+	}
+	if diverse.topN < 1 {
+		diverse.topN = 1
+	}
+	return diverse
+}
+
+func (diverse *diversity) Pick(store *EmojiStore, descriptor []float64, x, y int, rng *rand.Rand) *Emoji {
+
+	n := diversityCandidates
+	if diverse.topN > n {
+		n = diverse.topN
+	}
+
+	var matches []Match
+	for {
+		matches = matches[:0]
+		for _, el := range store.Candidates(descriptor, n) {
+			if diverse.maxUse <= 0 || diverse.usage[el.emoji] < diverse.maxUse {
+				matches = append(matches, el)
+			}
+		}
+
+		if len(matches) > 0 {
+			break
+		}
+		if n >= len(store.list) {
+			matches = store.Candidates(descriptor, 1) // every emoji is used up, can't honour the cap
+			break
+		}
+		n *= 2
+	}
+
+	if diverse.penalty > 0 {
+		nearby := diverse.nearby(x, y)
+
+		score := func(el Match) float64 {
+			return math.Sqrt(el.distance) + diverse.penalty*float64(nearby[el.emoji])
+		}
+		sort.SliceStable(matches, func(i, j int) bool { return score(matches[i]) < score(matches[j]) })
+	}
+
+	if len(matches) > diverse.topN {
+		matches = matches[:diverse.topN]
+	}
+
+	pick := matches[rng.Intn(len(matches))].emoji
+
+	diverse.chosen[y*diverse.columns+x] = pick
+	diverse.usage[pick]++
+	return pick
+}
+
+// how many times each emoji has been placed within radius of x, y
+func (diverse *diversity) nearby(x, y int) map[*Emoji]int {
+	counts := make(map[*Emoji]int)
+
+	for ny := y - diverse.radius; ny <= y+diverse.radius; ny++ { // anything not placed yet is just nil
+		for nx := x - diverse.radius; nx <= x+diverse.radius; nx++ {
+			if ny < 0 || nx < 0 || nx >= diverse.columns || ny*diverse.columns+nx >= len(diverse.chosen) {
+				continue
+			}
+			if emoji := diverse.chosen[ny*diverse.columns+nx]; emoji != nil {
+				counts[emoji]++
+			}
+		}
+	}
+	return counts
+}
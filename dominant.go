@@ -0,0 +1,159 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"sort"
+)
+
+// a colour that makes up a chunk of an emoji and how much of it (0-1 of the emoji's visible pixels)
+type DominantColor struct {
+	col      color.RGBA
+	coverage float64
+}
+
+// default number of clusters when dominant matching is asked for without saying how many
+const defaultDominantColors = 4
+
+// how many emojis (nearest by top colour) get weighed up properly when matching on dominant colours
+const dominantCandidates = 32
+
+const kMeansIterations = 10
+const kMeansSamples = 1024 // emojis are small but no point clustering more pixels than this
+
+/*
+plain k-means in CIELAB over the emoji's visible pixels
+seeded with the furthest-point method from the mean so the same emoji always gives the same clusters
+*/
+func (emoji *Emoji) GetDominant(k int) []DominantColor {
+
+	var pixels [][3]float64
+	var weights []float64
+
+	total := emoji.img.Bounds().Dx() * emoji.img.Bounds().Dy()
+	step := int(math.Max(1, math.Round(float64(total)/kMeansSamples)))
+
+	pixel := 0
+	LoopPixel(emoji.img, func(col []uint8) bool { // col is alpha premultiplied
+		pixel++
+		if (pixel-1)%step != 0 || col[3] == 0 {
+			return false
+		}
+
+		alpha := float64(col[3]) / 255
+		pixels = append(pixels, RGBToLab(float64(col[0])/alpha, float64(col[1])/alpha, float64(col[2])/alpha))
+		weights = append(weights, alpha)
+		return false
+	})
+
+	if len(pixels) == 0 || k <= 0 {
+		return nil
+	}
+	if k > len(pixels) {
+		k = len(pixels)
+	}
+
+	distance := func(p, q [3]float64) float64 {
+		return MetricCIE76.Distance(p, q)
+	}
+
+	// seed with the mean then keep adding whichever pixel is furthest from every centre so far
+	var mean [3]float64
+	var weightSum float64
+	for i, p := range pixels {
+		for c := range mean {
+			mean[c] += p[c] * weights[i]
+		}
+		weightSum += weights[i]
+	}
+	for c := range mean {
+		mean[c] /= weightSum
+	}
+
+	centres := [][3]float64{mean}
+	for len(centres) < k {
+		furthest, furthestDistance := 0, -1.0
+		for i, p := range pixels {
+			nearest := math.Inf(1)
+			for _, centre := range centres {
+				nearest = math.Min(nearest, distance(p, centre))
+			}
+			if nearest > furthestDistance {
+				furthest, furthestDistance = i, nearest
+			}
+		}
+		if furthestDistance <= 0 {
+			break // fewer distinct colours than clusters
+		}
+		centres = append(centres, pixels[furthest])
+	}
+
+	assignment := make([]int, len(pixels))
+	clusterWeights := make([]float64, len(centres))
+
+	for iteration := 0; iteration < kMeansIterations; iteration++ {
+
+		changed := false
+		for i, p := range pixels {
+			best, bestDistance := 0, math.Inf(1)
+			for j, centre := range centres {
+				if d := distance(p, centre); d < bestDistance {
+					best, bestDistance = j, d
+				}
+			}
+			if assignment[i] != best || iteration == 0 {
+				changed = true
+			}
+			assignment[i] = best
+		}
+
+		sums := make([][3]float64, len(centres))
+		clusterWeights = make([]float64, len(centres))
+		for i, p := range pixels {
+			for c := range p {
+				sums[assignment[i]][c] += p[c] * weights[i]
+			}
+			clusterWeights[assignment[i]] += weights[i]
+		}
+		for j := range centres {
+			if clusterWeights[j] > 0 {
+				for c := range centres[j] {
+					centres[j][c] = sums[j][c] / clusterWeights[j]
+				}
+			}
+		}
+
+		if !changed {
+			break
+		}
+	}
+
+	var dominant []DominantColor
+	for j, centre := range centres {
+		if clusterWeights[j] == 0 {
+			continue
+		}
+		r, g, b := LabToRGB(centre)
+		dominant = append(dominant, DominantColor{
+			col:      color.RGBA{R: r, G: g, B: b, A: 255},
+			coverage: clusterWeights[j] / weightSum,
+		})
+	}
+
+	sort.SliceStable(dominant, func(i, j int) bool { return dominant[i].coverage > dominant[j].coverage })
+	return dominant
+}
+
+// roughly the squared error you'd get per pixel by putting this emoji over a flat patch of target
+// multi-coloured emojis (flags...) get punished for all the colours that aren't the target
+func (store *EmojiStore) dominantDistance(target []float64, emoji *Emoji) float64 {
+	if len(emoji.dominant) == 0 {
+		return store.metric.DescriptorDistance(target, store.Describe([]color.Color{emoji.average}))
+	}
+
+	var expected float64
+	for _, el := range emoji.dominant {
+		expected += el.coverage * store.metric.DescriptorDistance(target, store.Describe([]color.Color{el.col}))
+	}
+	return expected
+}
@@ -4,7 +4,12 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"io"
 	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	"golang.org/x/image/draw"
@@ -13,6 +18,8 @@ import (
 type Settings struct {
 	backgroundColor color.RGBA
 	imageScale      float64
+	averaging       AverageMode
+	dominantColors  int // > 0 => work out this many dominant colours for every emoji as it's created
 }
 
 type EmojiKeg []*Brand
@@ -27,11 +34,33 @@ type EmojiStore struct {
 	list       []*Emoji
 	colorIndex [][]*Emoji // I chose to this instead of storing indices corresponding to EmojiStore.list as I reasoned they go hand in hand
 	colors     color.Palette
+	metric     ColorMetric
+	points     [][3]float64        // colors projected into the space of metric, kept in step with colors
+	tree       *colorTree          // built from points (or every emoji's grid) on first lookup, thrown away whenever they change
+	gridSize   int                 // > 1 => emojis are matched on a gridSize x gridSize grid of colours instead of their average
+	settings   Settings            // what the emojis were loaded with, averages depend on it
+	dominant   bool                // match on each emoji's dominant colours instead of its average (can't be used with a grid)
+	weights    map[string]float64  // Emoji.brand -> weight, distances are divided by it (mixed brands only)
+	byCode     map[string]*Emoji   // Emoji.info.code -> emoji, only has emojis that had their code when indexed
+	variants   map[string][]*Emoji // BaseCode -> the emoji and its skin tone variants, see Variants
 }
 type Emoji struct {
-	name    string
-	img     image.Image
-	average color.Color
+	name     string
+	img      image.Image
+	average  color.Color
+	grid     []color.Color   // row by row, only filled in when the store asks for a grid
+	dominant []DominantColor // most coverage first, only filled in when asked for
+	brand    string          // which brand it came from, only set in mixed (merged) brands
+	info     EmojiInfo
+}
+
+// what unicode says about an emoji, all empty when the source didn't say (e.g. cartridges)
+// for scraped emojis Emoji.name is the CLDR short name
+type EmojiInfo struct {
+	code            string // the actual unicode character(s)
+	group, subgroup string // e.g. Smileys & Emotion, face-smiling
+	version         string // emoji version it first appeared in, e.g. E13.0
+	status          string // fully-qualified, minimally-qualified, unqualified or component
 }
 
 var brandTranslations map[string]string
@@ -73,27 +102,113 @@ func LoopPixel(img image.Image, cb func(col []uint8) bool) bool {
 	return false
 }
 
-func (emoji *Emoji) GetAverage() color.Color {
+func (emoji *Emoji) GetAverage(imageSettings Settings) color.Color {
+	return emoji.GetGrid(1, imageSettings)[0]
+}
+
+// average colour of each cell of a size x size grid laid over the emoji, worked out however imageSettings.averaging says
+func (emoji *Emoji) GetGrid(size int, imageSettings Settings) []color.Color {
+
+	bounds := emoji.img.Bounds()
+	colorSums := make([][]float64, size*size) // [r, g, b, alpha]
+	cellAreas := make([]int, size*size)
+	for i := range colorSums {
+		colorSums[i] = make([]float64, 4)
+	}
+
+	background := ColorToBasic(imageSettings.backgroundColor)
+	hasBackground := background[3] > 0 // transparent background => only the emoji's own pixels count
 
-	colorSum := make([]uint64, 4)
+	pixel := 0
+	LoopPixel(emoji.img, func(col []uint8) bool { // col is alpha premultiplied
+		x, y := pixel%bounds.Dx(), pixel/bounds.Dx()
+		cell := (y*size/bounds.Dy())*size + x*size/bounds.Dx()
+		pixel++
 
-	LoopPixel(emoji.img, func(col []uint8) bool {
-		if col[3] > 0 {
-			for i, el := range col {
-				colorSum[i] += uint64(el)
+		cellAreas[cell]++
+		alpha := float64(col[3]) / 255
+
+		for i := 0; i < 3; i++ {
+			switch imageSettings.averaging {
+			case AverageLegacy:
+				if col[3] > 0 {
+					colorSums[cell][i] += float64(col[i])
+				}
+
+			case AverageAlpha:
+				colorSums[cell][i] += float64(col[i])
+				if hasBackground {
+					colorSums[cell][i] += float64(background[i]) * (1 - alpha)
+				}
+
+			case AverageLinear:
+				if hasBackground {
+					// composited in sRGB like draw.Over does when the mosaic is drawn, only the averaging is done in linear light
+					colorSums[cell][i] += linearise(float64(col[i]) + float64(background[i])*(1-alpha))
+				} else if alpha > 0 {
+					colorSums[cell][i] += linearise(float64(col[i])/alpha) * alpha
+				}
 			}
 		}
+		colorSums[cell][3] += alpha
 		return false
 	})
 
-	imgSize := emoji.img.Bounds().Dx() * emoji.img.Bounds().Dy()
-	averageColor := make([]uint8, 4)
-	for i := range colorSum {
-		averageColor[i] = uint8(math.Round(float64(colorSum[i]) / float64(imgSize)))
+	grid := make([]color.Color, size*size)
+	for cell := range grid {
+
+		weight := float64(cellAreas[cell])
+		if imageSettings.averaging != AverageLegacy && !hasBackground {
+			weight = colorSums[cell][3]
+		}
+
+		averageColor := make([]uint8, 4)
+		for i := 0; i < 3 && weight > 0; i++ {
+			average := colorSums[cell][i] / weight
+			if imageSettings.averaging == AverageLinear {
+				average = delinearise(average)
+			}
+			averageColor[i] = uint8(math.Round(math.Max(0, math.Min(255, average))))
+		}
+		averageColor[3] = 255
+		grid[cell] = BasicToColor(averageColor)
+	}
+	return grid
+}
+
+// what the emoji looks like to the matcher - its grid if it has one of that size, otherwise just the average
+func (emoji *Emoji) Colors(gridSize int) []color.Color {
+	if gridSize > 1 && len(emoji.grid) == gridSize*gridSize {
+		return emoji.grid
 	}
-	averageColor[3] = 255
-	// return color.RGBA{R: averageColor[0], G: averageColor[1], B: averageColor[2], A: 0}
-	return BasicToColor(averageColor)
+	return []color.Color{emoji.average}
+}
+
+// "U+1F468 U+200D U+1F469" (how unicode.org writes them) -> the characters themselves
+func ParseCodepoints(text string) (string, error) {
+	var code []rune
+	for _, el := range strings.Fields(text) {
+		point, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(el), "U+"), 16, 32)
+		if err != nil {
+			return "", fmt.Errorf("malformed codepoint %s", el)
+		}
+		code = append(code, rune(point))
+	}
+	return string(code), nil
+}
+
+// the characters -> "1f468_200d_1f469", safe to put in a file name
+func CodepointsToHex(code string) string {
+	var points []string
+	for _, el := range code {
+		points = append(points, strconv.FormatInt(int64(el), 16))
+	}
+	return strings.Join(points, "_")
+}
+
+// reverse of CodepointsToHex
+func HexToCodepoints(hex string) (string, error) {
+	return ParseCodepoints(strings.ReplaceAll(hex, "_", " "))
 }
 
 func GetTransparent(col color.Color, dimensions image.Rectangle) *image.RGBA {
@@ -106,9 +221,12 @@ func GetTransparent(col color.Color, dimensions image.Rectangle) *image.RGBA {
 	return canvas
 }
 
-func CreateEmoji(name string, img image.Image) *Emoji {
+func CreateEmoji(name string, img image.Image, imageSettings Settings) *Emoji {
 	emoji := Emoji{img: img, average: color.RGBA{}, name: name}
-	emoji.average = emoji.GetAverage()
+	emoji.average = emoji.GetAverage(imageSettings)
+	if imageSettings.dominantColors > 0 {
+		emoji.dominant = emoji.GetDominant(imageSettings.dominantColors)
+	}
 	return &emoji
 }
 
@@ -116,9 +234,19 @@ func CreateEmoji(name string, img image.Image) *Emoji {
 // 	emoji := CreateEmoji(name, img)
 // }
 
-func (store *EmojiStore) Add(name string, img image.Image, i int) {
+func (store *EmojiStore) Add(name string, img image.Image, i int) *Emoji {
+	return store.place(CreateEmoji(name, img, store.settings), i)
+}
+
+// same as Add but with descriptors that were already worked out (with the store's settings) so they don't get done again
+func (store *EmojiStore) AddPrecomputed(name string, img image.Image, i int, average color.Color, dominant []DominantColor) *Emoji {
+	return store.place(&Emoji{name: name, img: img, average: average, dominant: dominant}, i)
+}
 
-	emoji := CreateEmoji(name, img)
+func (store *EmojiStore) place(emoji *Emoji, i int) *Emoji {
+	if store.gridSize > 0 {
+		emoji.grid = emoji.GetGrid(store.gridSize, store.settings)
+	}
 
 	if i > -1 {
 		if i+1 > len(store.list) {
@@ -130,6 +258,26 @@ func (store *EmojiStore) Add(name string, img image.Image, i int) {
 		store.list = append(store.list, emoji)
 	}
 
+	store.index(emoji)
+	return emoji
+}
+
+func (store *EmojiStore) index(emoji *Emoji) {
+	if len(emoji.info.code) > 0 {
+		if store.byCode == nil {
+			store.byCode = make(map[string]*Emoji)
+		}
+		store.byCode[emoji.info.code] = emoji
+
+		if store.variants == nil {
+			store.variants = make(map[string][]*Emoji)
+		}
+		base := BaseCode(emoji.info.code)
+		variants := append(store.variants[base], emoji)
+		sort.SliceStable(variants, func(i, j int) bool { return firstTone(variants[i]) < firstTone(variants[j]) })
+		store.variants[base] = variants
+	}
+
 	for i, col := range store.colors {
 		if col == emoji.average {
 			store.colorIndex[i] = append(store.colorIndex[i], emoji)
@@ -139,10 +287,204 @@ func (store *EmojiStore) Add(name string, img image.Image, i int) {
 
 	store.colors = append(store.colors, emoji.average)
 	store.colorIndex = append(store.colorIndex, []*Emoji{emoji})
+	store.points = append(store.points, store.metric.ProjectColor(emoji.average))
+	store.tree = nil
 }
 
-func InitBrand(name string) *Brand {
-	return &Brand{name: name}
+// rebuilds the colour index from list, used after emojis have been removed
+func (store *EmojiStore) Reindex() {
+	store.colors, store.colorIndex, store.points, store.tree, store.byCode, store.variants = nil, nil, nil, nil, nil, nil
+
+	list := store.list
+	store.list = nil
+	for _, emoji := range list {
+		store.list = append(store.list, emoji)
+		store.index(emoji)
+	}
+}
+
+// the emoji for the given character(s), nil if there isn't one
+func (store *EmojiStore) Lookup(code string) *Emoji {
+	return store.byCode[code]
+}
+
+func (store *EmojiStore) SetMetric(metric ColorMetric) {
+	if metric == store.metric && len(store.points) == len(store.colors) {
+		return
+	}
+
+	store.metric = metric
+	store.points = make([][3]float64, len(store.colors))
+	for i, col := range store.colors {
+		store.points[i] = metric.ProjectColor(col)
+	}
+	store.tree = nil
+}
+
+// size <= 1 goes back to matching on averages
+func (store *EmojiStore) SetGrid(size int) {
+	if size <= 1 {
+		size = 0
+	}
+	if size == store.gridSize {
+		return
+	}
+
+	store.gridSize = size
+	for _, emoji := range store.list {
+		if size > 0 && len(emoji.grid) != size*size {
+			emoji.grid = emoji.GetGrid(size, store.settings)
+		}
+	}
+	store.tree = nil
+}
+
+func (store *EmojiStore) SetDominant(dominant bool) {
+	if dominant == store.dominant {
+		return
+	}
+
+	store.dominant = dominant
+	if dominant {
+		k := store.settings.dominantColors
+		if k <= 0 {
+			k = defaultDominantColors
+		}
+
+		for _, emoji := range store.list {
+			if len(emoji.dominant) == 0 {
+				emoji.dominant = emoji.GetDominant(k)
+			}
+		}
+	}
+	store.tree = nil
+}
+
+// call this before sharing the store between goroutines, Nearest builds the tree lazily otherwise
+func (store *EmojiStore) BuildTree() {
+	if store.tree != nil {
+		return
+	}
+
+	var points [][]float64
+
+	if store.gridSize > 0 {
+		for _, emoji := range store.list {
+			points = append(points, store.Describe(emoji.grid))
+		}
+	} else if store.dominant {
+		for _, emoji := range store.list {
+			top := emoji.average
+			if len(emoji.dominant) > 0 {
+				top = emoji.dominant[0].col
+			}
+			points = append(points, store.Describe([]color.Color{top}))
+		}
+	} else {
+		for i := range store.points {
+			points = append(points, store.points[i][:])
+		}
+	}
+	store.tree = buildColorTree(points, store.metric)
+}
+
+// projects colours with the store's metric and lays them end to end so they can be compared with the tree
+func (store *EmojiStore) Describe(cols []color.Color) []float64 {
+	descriptor := make([]float64, 0, len(cols)*3)
+	for _, col := range cols {
+		point := store.metric.ProjectColor(col)
+		descriptor = append(descriptor, point[:]...)
+	}
+	return descriptor
+}
+
+type Match struct {
+	emoji    *Emoji
+	distance float64 // squared, in the store's metric
+}
+
+// at least the n closest emojis to the descriptor, closest first
+func (store *EmojiStore) Candidates(descriptor []float64, n int) []Match {
+	store.BuildTree()
+
+	search := n
+	if store.dominant && search < dominantCandidates {
+		search = dominantCandidates // the tree only knows about each emoji's top colour, the rest are weighed up here
+	}
+
+	var matches []Match
+	for _, i := range store.tree.Nearest(descriptor, search) {
+		distance := store.metric.DescriptorDistance(descriptor, store.tree.points[i])
+
+		switch {
+		case store.gridSize > 0:
+			matches = append(matches, Match{store.list[i], distance})
+		case store.dominant:
+			matches = append(matches, Match{store.list[i], store.dominantDistance(descriptor, store.list[i])})
+		default:
+			for _, emoji := range store.colorIndex[i] {
+				matches = append(matches, Match{emoji, distance})
+			}
+		}
+	}
+
+	if store.weights != nil {
+		for i := range matches {
+			matches[i].distance /= store.weights[matches[i].emoji.brand]
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].distance < matches[j].distance })
+	if store.dominant && len(matches) > n {
+		matches = matches[:n]
+	}
+	return matches
+}
+
+// all emojis equally close to the descriptor (always one when matching on grids)
+func (store *EmojiStore) Nearest(descriptor []float64) []*Emoji {
+	store.BuildTree()
+
+	if store.dominant {
+		matches := store.Candidates(descriptor, 1)
+		if len(matches) == 0 {
+			return nil
+		}
+		return []*Emoji{matches[0].emoji}
+	}
+
+	if store.weights != nil {
+		// a heavier brand can beat a closer colour so look a bit further than the closest one
+		matches := store.Candidates(descriptor, weightedCandidates)
+
+		var fits []*Emoji
+		for _, el := range matches {
+			if el.distance > matches[0].distance {
+				break
+			}
+			fits = append(fits, el.emoji)
+		}
+		return fits
+	}
+
+	nearest := store.tree.Nearest(descriptor, 1)
+	if len(nearest) == 0 {
+		return nil
+	}
+
+	if store.gridSize > 0 {
+		return []*Emoji{store.list[nearest[0]]}
+	}
+	return store.colorIndex[nearest[0]]
+}
+
+// how many emojis get weighed up per lookup when brands are mixed with different weights
+const weightedCandidates = 8
+
+func InitBrand(name string, imageSettings Settings) *Brand {
+	brand := &Brand{name: name}
+	brand.emojis.settings = imageSettings
+	return brand
 }
 
 func CreateScalar(img image.Image, scale float64) (image.Rectangle, error) {
@@ -172,6 +514,187 @@ func (brand *Brand) GetScalar(scale float64) (image.Rectangle, error) {
 	return image.Rectangle{}, fmt.Errorf("emoji list is empty")
 }
 
+// the name a brand goes by once scraped (Goog => Google), case doesn't matter
+func CanonicalBrand(name string) string {
+	if actualName, exists := brandTranslations[name]; exists {
+		return actualName
+	}
+	for actualName := range brandTranslations {
+		if strings.EqualFold(actualName, name) {
+			return brandTranslations[actualName]
+		}
+	}
+	return name
+}
+
+// by name (case doesn't matter) or by the name unicode.org uses for it (e.g. Goog, Sams)
+func (emojis EmojiKeg) Find(name string) *Brand {
+	name = CanonicalBrand(name)
+
+	for _, brand := range emojis {
+		if strings.EqualFold(brand.name, name) {
+			return brand
+		}
+	}
+	return nil
+}
+
+// by Find or by where it is in the keg, counting from 1 (same as Pick)
+func (emojis EmojiKeg) Select(value string) (*Brand, error) {
+	if i, err := strconv.Atoi(value); err == nil {
+		if i < 1 || i > len(emojis) {
+			return nil, fmt.Errorf("brand %d doesn't exist, there are %d loaded", i, len(emojis))
+		}
+		return emojis[i-1], nil
+	}
+
+	if brand := emojis.Find(value); brand != nil {
+		return brand, nil
+	}
+
+	var names []string
+	for _, brand := range emojis {
+		names = append(names, brand.name)
+	}
+	return nil, fmt.Errorf("no brand called %s has been loaded (%s)", value, strings.Join(names, ", "))
+}
+
+// stdin is a terminal (/dev/null is a character device too but nobody's typing into it)
+func interactive() bool {
+	stat, err := os.Stdin.Stat()
+	if err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(stat, null)
+}
+
+// asks which brand to use, only if someone's there to answer
+func (emojis EmojiKeg) Pick() (*Brand, error) {
+	var names []string
+	for _, brand := range emojis {
+		names = append(names, brand.name)
+	}
+
+	if !interactive() {
+		return nil, fmt.Errorf("%d brands loaded (%s) - pick one with brand: (or -brand)", len(emojis), strings.Join(names, ", "))
+	}
+
+	input := "\n\nSelect brand:\n"
+	for i, brand := range emojis {
+		input += fmt.Sprintf("%v. %v\n", i+1, brand.String())
+	}
+	fmt.Println(input)
+
+	var i int
+	for {
+		fmt.Printf("Input a number [%d,% d] - ", 1, len(emojis))
+		if _, err := fmt.Scan(&i); err == io.EOF {
+			return nil, fmt.Errorf("no brand picked")
+		}
+		if i > 0 && i <= len(emojis) {
+			return emojis[i-1], nil
+		}
+	}
+}
+
+/*
+which brands to scrape or load, names are compared after CanonicalBrand
+include empty => everything that isn't excluded, a nil filter lets everything through
+*/
+type BrandFilter struct {
+	include []string
+	exclude []string
+}
+
+func (filter *BrandFilter) Allows(name string) bool {
+	if filter == nil {
+		return true
+	}
+
+	matches := func(list []string) bool {
+		for _, el := range list {
+			if strings.EqualFold(CanonicalBrand(el), CanonicalBrand(name)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if matches(filter.exclude) {
+		return false
+	}
+	return len(filter.include) == 0 || matches(filter.include)
+}
+
+/*
+pools every brand in the keg into one so a single mosaic can use all of them
+weights (brand name -> weight) favour some brands over others, unlisted ones get 1 and 0 leaves a brand out
+emojis are resized to the first brand's size
+*/
+func (emojis EmojiKeg) Merge(weights map[string]float64) (*Brand, error) {
+	if len(emojis) == 0 {
+		return nil, fmt.Errorf("no brands to mix")
+	}
+
+	resolved := make(map[*Brand]float64)
+	for name, weight := range weights {
+		brand := emojis.Find(name)
+		if brand == nil {
+			return nil, fmt.Errorf("can't mix in %s, no brand by that name has been loaded", name)
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("weight for %s can't be negative", name)
+		}
+		resolved[brand] = weight
+	}
+
+	scalar, err := emojis[0].GetScalar(1)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := InitBrand("", emojis[0].emojis.settings)
+	merged.emojis.weights = make(map[string]float64)
+
+	var names []string
+	for _, brand := range emojis {
+		weight, exists := resolved[brand]
+		if !exists {
+			weight = 1
+		}
+		if weight == 0 {
+			continue
+		}
+
+		names = append(names, brand.name)
+		merged.emojis.weights[brand.name] = weight
+
+		for _, emoji := range brand.emojis.list {
+			var mixed *Emoji
+			if emoji.img.Bounds().Size() == scalar.Size() {
+				copied := *emoji // average (and any grid) is still valid
+				mixed = &copied
+			} else {
+				mixed = CreateEmoji(emoji.name, Resize(emoji.img, scalar), merged.emojis.settings)
+				mixed.info = emoji.info // text and variants need its characters
+			}
+
+			mixed.brand = brand.name
+			merged.emojis.list = append(merged.emojis.list, mixed)
+			merged.emojis.index(mixed)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("every brand was weighted out of the mix")
+	}
+
+	merged.name = strings.Join(names, "+")
+	return merged, nil
+}
+
 func (emojis EmojiKeg) PreetifyBrandNames() {
 	for i := range emojis {
 		if actualName, exists := brandTranslations[emojis[i].name]; exists {
@@ -180,15 +703,16 @@ func (emojis EmojiKeg) PreetifyBrandNames() {
 	}
 }
 
+// one brand per line
 func (emojis EmojiKeg) String() string {
 	list := ""
 	total := 0
 	for _, brand := range emojis {
-		list += brand.String()
+		list += brand.String() + "\n"
 		total += len(brand.emojis.list)
 	}
 	if len(emojis) > 1 {
-		list += fmt.Sprintf("total - %d", total)
+		list += fmt.Sprintf("total - %d\n", total)
 	}
 	return list
 }
@@ -253,6 +777,7 @@ func (brand *Brand) CleanUp() { // mainly for: 1. reading cartridges (there will
 	}
 
 	brand.emojis.list = newList
+	brand.emojis.Reindex()
 }
 
 func (keg EmojiKeg) StripEmptyEmojis() {
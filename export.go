@@ -1,11 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"image"
 	"image/color"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"math"
 	"math/rand"
 	"os"
@@ -17,7 +19,25 @@ import (
 	"golang.org/x/image/draw"
 )
 
-func Export(fileName string, img image.Image, qualityScale float64) (err error) {
+// - as an input/output path means stdin/stdout
+const stdio = "-"
+
+// the real stdout, os.Stdout gets pointed at stderr when the output goes here (see ClaimStdout)
+var stdout = os.Stdout
+
+// png or jpg, format wins if it's given otherwise anything below full quality is a jpg
+func imageFormat(format string, qualityScale float64) string {
+	if len(format) > 0 {
+		return format
+	}
+	if qualityScale >= 1 {
+		return "png"
+	}
+	return "jpg"
+}
+
+// format only matters when the file name doesn't have an extension (or is - for stdout), empty => from the quality
+func Export(fileName string, img image.Image, format string, qualityScale float64) (err error) {
 
 	quality := int(math.Round(qualityScale * float64(100)))
 
@@ -25,6 +45,10 @@ func Export(fileName string, img image.Image, qualityScale float64) (err error)
 		return fmt.Errorf("quality can only be (0,1]")
 	}
 
+	if fileName == stdio {
+		return Encode(stdout, img, imageFormat(format, qualityScale), quality)
+	}
+
 	ext := filepath.Ext(fileName)
 	if len(ext) > 0 && ext != ".png" && ext != ".jpg" {
 		// return fmt.Errorf("only support jpg/png formats for output but got [%s]", ext, fileName)
@@ -32,32 +56,30 @@ func Export(fileName string, img image.Image, qualityScale float64) (err error)
 	}
 
 	if ext == "" {
-		if quality == 100 {
-			ext = ".png"
-		} else {
-			ext = ".jpg"
-		}
+		ext = "." + imageFormat(format, qualityScale)
 		fileName = fmt.Sprintf("%s%s", fileName, ext)
-
-	} else if quality < 100 && ext == ".png" {
-		fmt.Printf("[warning] quality value specified with png as the output format so ignored")
-		quality = 100
 	}
 
 	out, err := os.Create(fileName)
 	if err != nil {
-		fmt.Println(err)
+		return err
 	}
 	defer out.Close()
 
-	switch ext {
-	case ".jpg":
-		err = jpeg.Encode(out, img, &jpeg.Options{Quality: quality})
-	case ".png":
-		err = png.Encode(out, img)
-	}
+	return Encode(out, img, ext[1:], quality)
+}
 
-	return err
+func Encode(out io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case "jpg":
+		return jpeg.Encode(out, img, &jpeg.Options{Quality: quality})
+	case "png":
+		if quality < 100 {
+			fmt.Printf("[warning] quality value specified with png as the output format so ignored\n")
+		}
+		return png.Encode(out, img)
+	}
+	return fmt.Errorf("can only output png/jpg, not %s", format)
 }
 
 func (emojis EmojiKeg) Chunky(folderName string) error { // depecrated, only use cartridges
@@ -88,7 +110,12 @@ func (brand *Brand) ExportEmojis(folderName string) error {
 	for i, emoji := range brand.emojis.list {
 		img := Resize(emoji.img, scalar)
 
-		if err := Export(fmt.Sprintf("%s/%d__%s", folderName, i, emoji.name), img, 1); err != nil {
+		fileName := fmt.Sprintf("%s/%d__%s", folderName, i, emoji.name)
+		if len(emoji.info.code) > 0 {
+			fileName += "__" + CodepointsToHex(emoji.info.code) // so text output still works after reading the folder back
+		}
+
+		if err := Export(fileName, img, "", 1); err != nil {
 			return err
 		}
 	}
@@ -96,16 +123,30 @@ func (brand *Brand) ExportEmojis(folderName string) error {
 	return nil
 }
 
-func (emojis EmojiKeg) Export(folderName string) error {
+// format is png (one big atlas, columns wide) or zip (see CreateArchive)
+func (emojis EmojiKeg) Export(folderName string, format string, columns int) error {
 	for _, brand := range emojis {
-		if err := brand.CreateCartridge(fmt.Sprintf("%s/%s", folderName, brand.name)); err != nil {
+		fileName := fmt.Sprintf("%s/%s", folderName, brand.name)
+
+		var err error
+		switch format {
+		case "", "png":
+			err = brand.CreateCartridge(fileName, columns)
+		case "zip":
+			err = brand.CreateArchive(fileName)
+		default:
+			err = fmt.Errorf("unknown cartridge format %s", format)
+		}
+
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (brand *Brand) CreateCartridge(fileName string) error {
+// columns <= 0 => as close to square as possible
+func (brand *Brand) CreateCartridge(fileName string, columns int) error {
 
 	fmt.Printf("Saving cartridge %s -> %s\n", brand.name, fileName)
 
@@ -120,100 +161,303 @@ func (brand *Brand) CreateCartridge(fileName string) error {
 		return err
 	}
 
-	/* using our method above we get a number such that x^2 = number of emojis
-	=> x * x = (y + z) * (y + z) where y is a whole number and z is %1
-	=> Now we want k such that y * (y + z + k) = (y+z)^2
-	y^2 +yz + yk = y^2 + 2yz + z^2
-	=> yz + z^2 = yk
-	so, => k = (yz + z^2)/y
-	where we know y to be the floor of the square, z to be %1 of the square */
-
-	square := math.Sqrt(float64(len(brand.emojis.list)))
-	whole := math.Trunc(square) // y
-	tail := square - whole      // z
+	var emojis []*Emoji
+	for _, emoji := range brand.emojis.list {
+		if emoji != nil {
+			emojis = append(emojis, emoji)
+		}
+	}
 
-	canvasSize := image.Point{
-		scalar.Dx() * int(whole),
-		scalar.Dy() * int(math.Ceil(((whole*tail)+math.Pow(whole, 2))/whole)+1), // simply the formula applied (idk why +1 row is needed)
+	if columns <= 0 {
+		columns = int(math.Ceil(math.Sqrt(float64(len(emojis)))))
+	}
+	if columns > len(emojis) {
+		columns = len(emojis)
 	}
+	if columns == 0 {
+		return fmt.Errorf("brand %s has no emojis to save", brand.name)
+	}
+	rows := (len(emojis) + columns - 1) / columns // only the last row can be partly empty
 
 	canvas := GetTransparent(color.RGBA{}, image.Rectangle{
 		image.Point{0, 0},
-		canvasSize,
+		image.Point{scalar.Dx() * columns, scalar.Dy() * rows},
 	})
 
-	currentPosition := image.Rectangle{image.Point{0, 0}, scalar.Size()}
+	manifest := &CartridgeManifest{
+		Version:    cartridgeVersion,
+		Brand:      brand.name,
+		TileWidth:  scalar.Dx(),
+		TileHeight: scalar.Dy(),
+		Columns:    columns,
+		Rows:       rows,
+		Count:      len(emojis),
+	}
 
-	// translators
-	shiftRight := image.Point{scalar.Dx(), 0}
-	shiftDown := image.Point{0, scalar.Dy()}
+	for i, emoji := range emojis {
+		position := image.Point{(i % columns) * scalar.Dx(), (i / columns) * scalar.Dy()}
+		draw.Draw(canvas, scalar.Add(position), Resize(emoji.img, scalar), image.Point{0, 0}, draw.Over)
 
-	for _, emoji := range brand.emojis.list {
+		manifest.Tiles = append(manifest.Tiles, NewManifestTile(emoji))
+	}
 
-		if emoji == nil {
-			continue
-		}
+	cartridgeName := fmt.Sprintf("%s-%dx%d.png", fileName, scalar.Dx(), scalar.Dy())
+	if err := Export(cartridgeName, canvas, "", 1); err != nil {
+		return err
+	}
+
+	return manifest.Write(ManifestPath(cartridgeName))
+}
+
+type ConvertSettings struct {
+	imageScale float64
+	metric     ColorMetric
+	gridSize   int // match each emoji on a gridSize x gridSize grid of colours, <= 1 => just the average
+	dither     DitherMode
+	dominant   bool // match on dominant colours rather than the average
+
+	// diversity controls, see diversity
+	penalty float64
+	radius  int
+	maxUse  int
+	topN    int
+
+	seed   int64
+	seeded bool // false => seeded from the clock, so every run is different
+
+	// quadtree layout, see QuadtreeLeaves
+	quadtree  bool
+	maxTile   int
+	threshold float64
+
+	metadata string // Emojify only - where to write which emoji (and brand) ended up in each tile as JSON, empty => don't
+	format   string // Emojify only - png/jpg, empty => from the output's extension or the quality
+}
 
-		scaledEmoji := Resize(emoji.img, scalar)
+// an emojified image along with what was placed where
+type Mosaic struct {
+	img           *image.RGBA
+	brand         string
+	columns, rows int
+	tileSize      image.Point // of a single cell, quadtree tiles are multiples of it
+	tiles         []Tile
+}
 
-		draw.Draw(canvas, currentPosition, scaledEmoji, image.Point{0, 0}, draw.Over)
+type Tile struct {
+	x, y, size int // in cells
+	emoji      *Emoji
+}
 
-		if currentPosition.Max.X == canvasSize.X {
-			currentPosition = currentPosition.Add(shiftDown)
+type tileMetadata struct {
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	Size  int    `json:"size"`
+	Name  string `json:"name"`
+	Brand string `json:"brand"`
+}
 
-			currentPosition.Min.X = 0
-			currentPosition.Max.X = scalar.Dx()
+type mosaicMetadata struct {
+	Brand      string         `json:"brand"`
+	Columns    int            `json:"columns"`
+	Rows       int            `json:"rows"`
+	TileWidth  int            `json:"tileWidth"`
+	TileHeight int            `json:"tileHeight"`
+	Tiles      []tileMetadata `json:"tiles"`
+}
 
-		} else {
-			currentPosition = currentPosition.Add(shiftRight)
+func (mosaic *Mosaic) WriteMetadata(fileName string) error {
+
+	metadata := mosaicMetadata{
+		Brand:      mosaic.brand,
+		Columns:    mosaic.columns,
+		Rows:       mosaic.rows,
+		TileWidth:  mosaic.tileSize.X,
+		TileHeight: mosaic.tileSize.Y,
+	}
+
+	for _, tile := range mosaic.tiles {
+		brandName := tile.emoji.brand
+		if len(brandName) == 0 {
+			brandName = mosaic.brand
 		}
+		metadata.Tiles = append(metadata.Tiles, tileMetadata{X: tile.x, Y: tile.y, Size: tile.size, Name: tile.emoji.name, Brand: brandName})
 	}
 
-	if err := Export(fmt.Sprintf("%s-%dx%d.png", fileName, scalar.Dx(), scalar.Dy()), canvas, 1); err != nil {
+	out, err := os.Create(fileName)
+	if err != nil {
 		return err
 	}
-	return nil
+	defer out.Close()
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(metadata)
 }
 
-func (emojis EmojiKeg) Emojify(inputName string, outputPath string, imageScale float64, quality float64) error {
+// one output per brand, in outputPath if there is one (named after the brand) otherwise next to wherever it's run
+func (emojis EmojiKeg) Emojify(inputName string, outputPath string, convertSettings ConvertSettings, quality float64) error {
+
+	if len(outputPath) > 0 {
+		if err := os.MkdirAll(outputPath, 0700); err != nil {
+			return err
+		}
+	}
 
+	metadata := convertSettings.metadata
 	for _, brand := range emojis {
-		if err := brand.Emojify(inputName, fmt.Sprintf("%s/%s", outputPath, brand.name), imageScale, quality); err != nil {
+		var outputName string
+		if len(outputPath) > 0 {
+			outputName = fmt.Sprintf("%s/%s", outputPath, brand.name)
+		}
+
+		if len(metadata) > 0 { // file.json => file-Apple.json so they don't overwrite each other
+			convertSettings.metadata = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(metadata, filepath.Ext(metadata)), brand.name, filepath.Ext(metadata))
+		}
+
+		if err := brand.Emojify(inputName, outputName, convertSettings, quality); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (brand *Brand) Emojify(inputName string, outputName string, imageScale float64, quality float64) error {
-
-	fmt.Printf("Emojifying %s with brand %s\n", inputName, brand.name)
+func (brand *Brand) Emojify(inputName string, outputName string, convertSettings ConvertSettings, quality float64) error {
 
-	imageData, err := OpenImage(inputName)
-	if err != nil {
-		return err
+	source, name := inputName, filepath.Base(inputName)
+	if inputName == stdio {
+		source, name = "stdin", "stdin"
 	}
+	fmt.Printf("Emojifying %s with brand %s\n", source, brand.name)
 
-	img, err := brand.ConvertImage(imageData, imageScale)
+	mosaic, err := brand.emojifyFile(inputName, convertSettings)
 	if err != nil {
 		return err
 	}
+	img := mosaic.img
+	fmt.Printf("New dimensions: %s\n", img.Bounds().Max)
 
 	if len(outputName) == 0 {
-		name := filepath.Base(inputName)
 		outputName = fmt.Sprintf("%v-%v-%vx%v", strings.TrimSuffix(name, filepath.Ext(name)), brand.name, img.Bounds().Max.X, img.Bounds().Max.Y)
 	}
 
-	if err := Export(outputName, img, quality); err != nil {
+	if err := Export(outputName, img, convertSettings.format, quality); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (brand *Brand) ConvertImage(img image.Image, imageScale float64) (image.Image, error) {
+// the mosaic for an image (and its metadata if it's wanted), without saving the image
+func (brand *Brand) emojifyFile(inputName string, convertSettings ConvertSettings) (*Mosaic, error) {
+
+	imageData, err := OpenImage(inputName)
+	if err != nil {
+		return nil, err
+	}
+
+	mosaic, err := brand.CreateMosaic(imageData, convertSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(convertSettings.metadata) > 0 {
+		if err := mosaic.WriteMetadata(convertSettings.metadata); err != nil {
+			return nil, err
+		}
+	}
+	return mosaic, nil
+}
+
+// same as Emojify but writes the mosaic as lines of actual emoji characters (to stdout if outputName is empty)
+// columns > 0 caps how many emojis wide each line can be
+func (brand *Brand) EmojifyText(inputName string, outputName string, columns int, convertSettings ConvertSettings) error {
+
+	if convertSettings.quadtree {
+		return fmt.Errorf("text can't have emojis of different sizes, the quadtree layout can't be used")
+	}
+
+	imageData, err := OpenImage(inputName)
+	if err != nil {
+		return err
+	}
+
+	if width := imageData.Bounds().Dx(); columns > 0 && float64(width)*convertSettings.imageScale > float64(columns) {
+		scale := float64(columns) / float64(width)
+		for math.Ceil(float64(width)*scale) > float64(columns) {
+			scale = math.Nextafter(scale, 0) // columns/width can come out a hair over, which CreateScalar rounds up to an extra column
+		}
+		convertSettings.imageScale = scale
+	}
+
+	mosaic, err := brand.CreateMosaic(imageData, convertSettings)
+	if err != nil {
+		return err
+	}
+
+	text, err := mosaic.Text()
+	if err != nil {
+		return err
+	}
+
+	if len(outputName) == 0 || outputName == stdio {
+		fmt.Fprint(stdout, text)
+		return nil
+	}
+
+	if filepath.Ext(outputName) == "" {
+		outputName += ".txt"
+	}
+	return os.WriteFile(outputName, []byte(text), 0644)
+}
+
+// the mosaic as lines of emoji characters, only works when every tile is one cell
+func (mosaic *Mosaic) Text() (string, error) {
+
+	rows := make([][]string, mosaic.rows)
+	for i := range rows {
+		rows[i] = make([]string, mosaic.columns)
+	}
+
+	for _, tile := range mosaic.tiles {
+		if tile.size != 1 {
+			return "", fmt.Errorf("text can't have emojis of different sizes")
+		}
+		if len(tile.emoji.info.code) == 0 {
+			return "", fmt.Errorf("emoji %s doesn't know its unicode character - scrape with html or use a folder exported from it", tile.emoji.name)
+		}
+		rows[tile.y][tile.x] = tile.emoji.info.code
+	}
+
+	var text strings.Builder
+	for _, row := range rows {
+		text.WriteString(strings.Join(row, ""))
+		text.WriteString("\n")
+	}
+	return text.String(), nil
+}
+
+func (brand *Brand) ConvertImage(img image.Image, convertSettings ConvertSettings) (image.Image, error) {
+	mosaic, err := brand.CreateMosaic(img, convertSettings)
+	if err != nil {
+		return nil, err
+	}
+	return mosaic.img, nil
+}
 
-	imageScalar, err := CreateScalar(img, imageScale)
+// gets the brand's colour index ready for these settings, does nothing if it already is so mosaics can be made concurrently after one call
+func (brand *Brand) Prepare(convertSettings ConvertSettings) {
+	brand.mu.Lock()
+	defer brand.mu.Unlock()
+
+	brand.emojis.SetMetric(convertSettings.metric)
+	brand.emojis.SetGrid(convertSettings.gridSize) // <= 1 => averages
+	brand.emojis.SetDominant(convertSettings.dominant)
+	brand.emojis.BuildTree()
+}
+
+func (brand *Brand) CreateMosaic(img image.Image, convertSettings ConvertSettings) (*Mosaic, error) {
+
+	imageScalar, err := CreateScalar(img, convertSettings.imageScale)
 	if err != nil {
 		return nil, err
 	}
@@ -224,53 +468,162 @@ func (brand *Brand) ConvertImage(img image.Image, imageScale float64) (image.Ima
 
 	emojiScalar := brand.emojis.list[0].img.Bounds()
 
+	gridSize := 1
+	if convertSettings.gridSize > 1 {
+		gridSize = convertSettings.gridSize
+	}
+
+	if gridSize > 1 && convertSettings.dominant {
+		return nil, fmt.Errorf("dominant colour matching works on whole emojis, it can't be used with a grid")
+	}
+
+	if convertSettings.quadtree && convertSettings.dither != DitherNone {
+		return nil, fmt.Errorf("dithering needs every emoji to be the same size, it can't be used with the quadtree layout")
+	}
+
+	brand.Prepare(convertSettings)
+
 	canvasSize := image.Rectangle{Max: image.Point{
 		X: imageScalar.Dx() * emojiScalar.Dx(),
 		Y: imageScalar.Dy() * emojiScalar.Dy(),
 	}}
 
-	emojified := GetTransparent(color.RGBA{}, canvasSize)
-	fmt.Printf("New dimensions: %s\n", emojified.Bounds().Max)
+	// averages were worked out against this background so render onto it too
+	emojified := GetTransparent(brand.emojis.settings.backgroundColor, canvasSize)
 
+	mosaic := &Mosaic{
+		img:      emojified,
+		brand:    brand.name,
+		columns:  imageScalar.Dx(),
+		rows:     imageScalar.Dy(),
+		tileSize: emojiScalar.Size(),
+	}
 
-	currentPosition := image.Rectangle{Min: image.Point{0, 0}, Max: emojiScalar.Max}
-	source := Resize(img, imageScalar)
-
-	rand.Seed(time.Now().Unix())
-	randomConstantFits := make(map[color.Color]*Emoji)
+	// every emoji covers gridSize x gridSize pixels of the source
+	sourceScalar := image.Rect(0, 0, imageScalar.Dx()*gridSize, imageScalar.Dy()*gridSize)
+	source := GetTransparent(color.RGBA{}, sourceScalar)
+	resized := Resize(img, sourceScalar)
+	draw.Draw(source, sourceScalar, resized, resized.Bounds().Min, draw.Over)
 
-	LoopPixel(source, func(col []uint8) bool {
-		pixColor := BasicToColor(col)
+	seed := time.Now().UnixNano()
+	if convertSettings.seeded {
+		seed = convertSettings.seed
+	}
+	rng := rand.New(rand.NewSource(seed)) // own source so the same seed always gives the same mosaic
+	randomConstantFits := make(map[string]*Emoji)
+	diverse := newDiversity(convertSettings, imageScalar.Dx(), imageScalar.Dy())
+
+	// leftover error for every source pixel, only used when dithering
+	kernel := convertSettings.dither.Kernel()
+	var diffused []float64
+	if kernel != nil {
+		diffused = make([]float64, sourceScalar.Dx()*sourceScalar.Dy()*3)
+	}
 
-		var bestRandFit *Emoji
-		var ok bool
+	choose := func(cell []color.Color, key []byte, x, y int) *Emoji {
+		if diverse != nil {
+			return diverse.Pick(&brand.emojis, brand.emojis.Describe(cell), x, y, rng)
+		}
 
 		// this ensures a consistency in colour but still creates a different image each time
-		if bestRandFit, ok = randomConstantFits[pixColor]; !ok {
+		bestRandFit, ok := randomConstantFits[string(key)]
+		if !ok {
+			potentialFits := brand.emojis.Nearest(brand.emojis.Describe(cell))
+			bestRandFit = potentialFits[rng.Intn(len(potentialFits))]
 
-			potentialFits := brand.emojis.colorIndex[brand.emojis.colors.Index(pixColor)]
-			bestRandFit = potentialFits[rand.Intn(len(potentialFits))]
+			randomConstantFits[string(key)] = bestRandFit
+		}
+		return bestRandFit
+	}
+
+	if convertSettings.quadtree {
+		maxTile, threshold := convertSettings.maxTile, convertSettings.threshold
+		if maxTile <= 0 {
+			maxTile = defaultMaxTile
+		}
+		if threshold <= 0 {
+			threshold = defaultThreshold
+		}
 
-			randomConstantFits[pixColor] = bestRandFit
+		type scaledKey struct {
+			emoji *Emoji
+			size  int
 		}
+		scaled := make(map[scaledKey]image.Image) // big blocks reuse the same few emojis so don't resize them every time
 
-		// translators
-		shiftRight := image.Point{emojiScalar.Dx(), 0}
-		shiftDown := image.Point{0, emojiScalar.Dy()}
+		for _, block := range QuadtreeLeaves(source, gridSize, imageScalar.Dx(), imageScalar.Dy(), maxTile, threshold) {
+			cell, key := block.Sample(source, gridSize)
+			emoji := choose(cell, key, block.x, block.y)
 
-		draw.Draw(emojified, currentPosition, bestRandFit.img, bestRandFit.img.Bounds().Min, draw.Over)
+			tile, ok := scaled[scaledKey{emoji, block.size}]
+			if !ok {
+				tile = Resize(emoji.img, image.Rect(0, 0, emojiScalar.Dx()*block.size, emojiScalar.Dy()*block.size))
+				scaled[scaledKey{emoji, block.size}] = tile
+			}
 
-		if currentPosition.Max.X >= canvasSize.Dx() {
-			currentPosition = currentPosition.Add(shiftDown)
+			position := tile.Bounds().Sub(tile.Bounds().Min).Add(image.Point{block.x * emojiScalar.Dx(), block.y * emojiScalar.Dy()})
+			draw.Draw(emojified, position, tile, tile.Bounds().Min, draw.Over)
+			mosaic.tiles = append(mosaic.tiles, Tile{block.x, block.y, block.size, emoji})
+		}
 
-			currentPosition.Min.X = 0
-			currentPosition.Max.X = emojiScalar.Dx()
+		return mosaic, nil
+	}
 
-		} else {
-			currentPosition = currentPosition.Add(shiftRight)
+	cell := make([]color.Color, gridSize*gridSize)
+	adjusted := make([][3]float64, gridSize*gridSize)
+
+	for y := 0; y < imageScalar.Dy(); y++ {
+		for x := 0; x < imageScalar.Dx(); x++ {
+
+			offset := convertSettings.dither.Offset(x, y)
+
+			var key []byte
+			for i := range cell {
+				pixel := (y*gridSize+i/gridSize)*sourceScalar.Dx() + x*gridSize + i%gridSize
+				col := source.Pix[pixel*4 : pixel*4+4]
+
+				basic := make([]uint8, 4)
+				for c := 0; c < 3; c++ {
+					adjusted[i][c] = float64(col[c]) + offset
+					if diffused != nil {
+						adjusted[i][c] += diffused[pixel*3+c]
+					}
+					adjusted[i][c] = math.Max(0, math.Min(255, adjusted[i][c]))
+					basic[c] = uint8(math.Round(adjusted[i][c]))
+				}
+				basic[3] = col[3]
+
+				cell[i] = BasicToColor(basic)
+				key = append(key, basic...)
+			}
+
+			bestRandFit := choose(cell, key, x, y)
+
+			position := emojiScalar.Add(image.Point{x * emojiScalar.Dx(), y * emojiScalar.Dy()})
+			draw.Draw(emojified, position, bestRandFit.img, bestRandFit.img.Bounds().Min, draw.Over)
+			mosaic.tiles = append(mosaic.tiles, Tile{x, y, 1, bestRandFit})
+
+			if diffused == nil {
+				continue
+			}
+
+			// push whatever the emoji got wrong onto the same spot of the neighbouring cells
+			for i, col := range bestRandFit.Colors(gridSize) {
+				target := ColorToBasic(col)
+				for _, el := range kernel {
+					nx, ny := x+el.dx, y+el.dy
+					if nx < 0 || ny < 0 || nx >= imageScalar.Dx() || ny >= imageScalar.Dy() {
+						continue
+					}
+
+					neighbour := (ny*gridSize+i/gridSize)*sourceScalar.Dx() + nx*gridSize + i%gridSize
+					for c := 0; c < 3; c++ {
+						diffused[neighbour*3+c] += (adjusted[i][c] - float64(target[c])) * el.weight
+					}
+				}
+			}
 		}
-		return false
-	})
+	}
 
-	return emojified, nil
+	return mosaic, nil
 }
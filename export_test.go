@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+const syntheticTile = 16 // 16*0.3 isn't whole, which is where cutting tiles out of a scaled atlas used to drift
+
+// every tile a different solid colour so a tile that comes back in the wrong place can't hide
+func syntheticColor(i int) color.RGBA {
+	return color.RGBA{R: uint8(i * 37), G: uint8(i * 91), B: uint8(255 - i*13), A: 255}
+}
+
+func syntheticBrand(n int) *Brand {
+	brand := InitBrand("Synthetic", Settings{imageScale: 1})
+
+	for i := 0; i < n; i++ {
+		img := GetTransparent(syntheticColor(i), image.Rect(0, 0, syntheticTile, syntheticTile))
+		emoji := brand.emojis.Add(fmt.Sprintf("emoji %d", i), img, i)
+		emoji.info.code = string(rune(0x1F600 + i))
+	}
+	brand.emojis.Reindex()
+	return brand
+}
+
+func closeTo(a, b uint8) bool {
+	return a-b <= 1 || b-a <= 1
+}
+
+func TestCartridgeRoundTrip(t *testing.T) {
+	const count = 100
+
+	for _, columns := range []int{25, 7, 0} {
+		for _, escale := range []float64{1, 0.5, 0.3} {
+			t.Run(fmt.Sprintf("cols %d escale %v", columns, escale), func(t *testing.T) {
+
+				dir := t.TempDir()
+				if err := syntheticBrand(count).CreateCartridge(filepath.Join(dir, "Synthetic"), columns); err != nil {
+					t.Fatal(err)
+				}
+				fileName := filepath.Join(dir, fmt.Sprintf("Synthetic-%dx%d.png", syntheticTile, syntheticTile))
+
+				// second time round the averages come from the descriptors the first load cached
+				for load := 0; load < 2; load++ {
+					brand, err := ReadCartridgeFromFile(fileName, "", 0, 0, Settings{imageScale: escale, backgroundColor: color.RGBA{A: 255}})
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if len(brand.emojis.list) != count {
+						t.Fatalf("load %d: got %d emojis, want %d", load, len(brand.emojis.list), count)
+					}
+
+					for i, emoji := range brand.emojis.list {
+						if want := fmt.Sprintf("emoji %d", i); emoji.name != want {
+							t.Fatalf("load %d: emoji %d is %q, want %q", load, i, emoji.name, want)
+						}
+						if want := string(rune(0x1F600 + i)); emoji.info.code != want {
+							t.Fatalf("load %d: emoji %d has code %q, want %q", load, i, emoji.info.code, want)
+						}
+
+						want := syntheticColor(i)
+						bounds := emoji.img.Bounds()
+						for _, at := range []image.Point{bounds.Min, bounds.Max.Sub(image.Point{1, 1})} {
+							if got := color.RGBAModel.Convert(emoji.img.At(at.X, at.Y)).(color.RGBA); got != want {
+								t.Fatalf("load %d: emoji %d has %v at %v, want %v", load, i, got, at, want)
+							}
+						}
+
+						average := color.RGBAModel.Convert(emoji.average).(color.RGBA)
+						if !closeTo(average.R, want.R) || !closeTo(average.G, want.G) || !closeTo(average.B, want.B) {
+							t.Fatalf("load %d: emoji %d averages %v, want %v", load, i, average, want)
+						}
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaults for downloading chart pages
+const defaultTimeout = 2 * time.Minute // per attempt, full-emoji-list.html is tens of MB
+const defaultRetries = 3
+const retryBackoff = 2 * time.Second // doubles after every failed attempt
+const progressInterval = 500 * time.Millisecond
+
+/*
+downloads with a timeout and retries, and keeps what it got on disk so unchanged pages aren't downloaded again
+cacheDir empty => nothing gets cached
+*/
+type Fetcher struct {
+	client   *http.Client
+	retries  int
+	cacheDir string
+}
+
+// what's remembered about a cached download to ask the server whether it changed
+type cacheEntry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+func NewFetcher(timeout time.Duration, retries int, cacheDir string) *Fetcher {
+	return &Fetcher{client: &http.Client{Timeout: timeout}, retries: retries, cacheDir: cacheDir}
+}
+
+// the user's cache folder, empty if there isn't one
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "emojiportal")
+}
+
+func (fetcher *Fetcher) cachePaths(url string) (body, meta string) {
+	sum := sha1.Sum([]byte(url))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(fetcher.cacheDir, name), filepath.Join(fetcher.cacheDir, name+".json")
+}
+
+func (fetcher *Fetcher) cached(url string) (*cacheEntry, string) {
+	if len(fetcher.cacheDir) == 0 {
+		return nil, ""
+	}
+
+	body, meta := fetcher.cachePaths(url)
+	data, err := os.ReadFile(meta)
+	if err != nil || !fileExists(body) {
+		return nil, ""
+	}
+
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil || entry.URL != url {
+		return nil, ""
+	}
+	return entry, body
+}
+
+func (fetcher *Fetcher) Fetch(url string) (io.ReadCloser, error) {
+
+	entry, cachedBody := fetcher.cached(url)
+
+	var err error
+	wait := retryBackoff
+
+	for attempt := 0; attempt <= fetcher.retries; attempt++ {
+		if attempt > 0 {
+			fmt.Printf("\n[warning] %s, retrying in %s (%d/%d)\n", err, wait, attempt, fetcher.retries)
+			time.Sleep(wait)
+			wait *= 2
+		}
+
+		var body io.ReadCloser
+		var retry bool
+		if body, retry, err = fetcher.attempt(url, entry, cachedBody); err == nil {
+			return body, nil
+		}
+		if !retry {
+			break
+		}
+	}
+
+	if entry != nil {
+		fmt.Printf("[warning] couldn't download %s, using the copy from %s: %s\n", url, cachedBody, err)
+		return os.Open(cachedBody)
+	}
+	return nil, err
+}
+
+// retry => the request might work if it's tried again
+func (fetcher *Fetcher) attempt(url string, entry *cacheEntry, cachedBody string) (body io.ReadCloser, retry bool, err error) {
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if entry != nil {
+		if len(entry.ETag) > 0 {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if len(entry.LastModified) > 0 {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := fetcher.client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && entry != nil:
+		fmt.Printf("(unchanged, using cache) ")
+		file, err := os.Open(cachedBody)
+		return file, false, err
+
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, true, fmt.Errorf("%s returned %s", url, resp.Status)
+
+	case resp.StatusCode != http.StatusOK:
+		return nil, false, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	progress := &progressWriter{total: resp.ContentLength}
+	defer progress.Finish()
+
+	if len(fetcher.cacheDir) == 0 {
+		var buffer bytes.Buffer
+		if _, err := io.Copy(io.MultiWriter(&buffer, progress), resp.Body); err != nil {
+			return nil, true, err
+		}
+		return io.NopCloser(&buffer), false, nil
+	}
+
+	// straight into the cache, only replacing what was there once the whole page is in
+	if err := os.MkdirAll(fetcher.cacheDir, 0700); err != nil {
+		return nil, false, err
+	}
+
+	temp, err := os.CreateTemp(fetcher.cacheDir, "download-*")
+	if err != nil {
+		return nil, false, err
+	}
+	defer os.Remove(temp.Name()) // no-op once it's been renamed
+
+	_, err = io.Copy(io.MultiWriter(temp, progress), resp.Body)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, true, err
+	}
+
+	bodyPath, metaPath := fetcher.cachePaths(url)
+	if err := os.Rename(temp.Name(), bodyPath); err != nil {
+		return nil, false, err
+	}
+
+	meta, _ := json.Marshal(cacheEntry{URL: url, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")})
+	if err := os.WriteFile(metaPath, meta, 0600); err != nil {
+		fmt.Printf("[warning] couldn't save cache info for %s: %s\n", url, err)
+	}
+
+	file, err := os.Open(bodyPath)
+	return file, false, err
+}
+
+// prints how much has been downloaded every so often
+type progressWriter struct {
+	total   int64 // -1 => server didn't say
+	written int64
+	printed int64 // written as of the last print
+	last    time.Time
+}
+
+func (progress *progressWriter) Write(p []byte) (int, error) {
+	if progress.written == 0 {
+		fmt.Printf("\n") // keep whatever was printed before the download
+	}
+
+	progress.written += int64(len(p))
+	if time.Since(progress.last) >= progressInterval {
+		progress.last = time.Now()
+		progress.print()
+	}
+	return len(p), nil
+}
+
+func (progress *progressWriter) print() {
+	progress.printed = progress.written
+	if progress.total > 0 {
+		fmt.Printf("\r%.1f/%.1f MB (%d%%) ", megabytes(progress.written), megabytes(progress.total), progress.written*100/progress.total)
+	} else {
+		fmt.Printf("\r%.1f MB ", megabytes(progress.written))
+	}
+}
+
+func (progress *progressWriter) Finish() {
+	if progress.written > 0 {
+		if progress.printed != progress.written {
+			progress.print()
+		}
+		fmt.Printf("\n")
+	}
+}
+
+func megabytes(n int64) float64 {
+	return float64(n) / (1 << 20)
+}
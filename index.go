@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+/*
+	k-d tree over emoji descriptors so we don't scan every single colour for every single pixel
+	a descriptor is one or more colours (already projected with the metric) laid end to end - [x0 y0 z0 x1 y1 z1 ...]
+	distance between two descriptors is the sum of the metric's distance for each colour
+*/
+
+type colorTree struct {
+	metric ColorMetric
+	points [][]float64
+	nodes  []treeNode
+	root   int
+}
+
+type treeNode struct {
+	point       int // index into colorTree.points
+	axis        int
+	left, right int // -1 => no child
+}
+
+func buildColorTree(points [][]float64, metric ColorMetric) *colorTree {
+	tree := &colorTree{metric: metric, points: points}
+
+	order := make([]int, len(points))
+	for i := range order {
+		order[i] = i
+	}
+
+	tree.root = tree.build(order)
+	return tree
+}
+
+func (tree *colorTree) build(order []int) int {
+	if len(order) == 0 {
+		return -1
+	}
+
+	// split along whichever axis has the largest spread
+	axis, spread := 0, -1.0
+	for a := range tree.points[order[0]] {
+		min, max := math.Inf(1), math.Inf(-1)
+		for _, i := range order {
+			min = math.Min(min, tree.points[i][a])
+			max = math.Max(max, tree.points[i][a])
+		}
+		if max-min > spread {
+			axis, spread = a, max-min
+		}
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		return tree.points[order[i]][axis] < tree.points[order[j]][axis]
+	})
+	median := len(order) / 2
+
+	node := len(tree.nodes)
+	tree.nodes = append(tree.nodes, treeNode{point: order[median], axis: axis})
+
+	left := tree.build(order[:median])
+	right := tree.build(order[median+1:])
+	tree.nodes[node].left, tree.nodes[node].right = left, right
+
+	return node
+}
+
+// the k closest points to target, closest first
+func (tree *colorTree) Nearest(target []float64, k int) []int {
+	if k <= 0 || tree.root < 0 {
+		return nil
+	}
+
+	best := &candidates{size: k}
+	if tree.metric == MetricCIEDE2000 {
+		// ΔE00 has no bound the tree can prune with that's actually safe, so every point gets checked
+		for i := range tree.points {
+			best.Push(i, tree.metric.DescriptorDistance(target, tree.points[i]))
+		}
+	} else {
+		tree.search(tree.root, target, tree.metric, best)
+	}
+
+	indices := make([]int, len(best.list))
+	for i, el := range best.list {
+		indices[i] = el.point
+	}
+	return indices
+}
+
+func (tree *colorTree) search(node int, target []float64, metric ColorMetric, best *candidates) {
+	if node < 0 {
+		return
+	}
+
+	current := tree.nodes[node]
+	best.Push(current.point, metric.DescriptorDistance(target, tree.points[current.point]))
+
+	diff := target[current.axis] - tree.points[current.point][current.axis]
+	near, far := current.left, current.right
+	if diff > 0 {
+		near, far = far, near
+	}
+
+	tree.search(near, target, metric, best)
+	if !best.Full() || metric.AxisBound(current.axis, diff) < best.Worst() {
+		tree.search(far, target, metric, best)
+	}
+}
+
+type candidate struct {
+	point    int
+	distance float64
+}
+
+// tiny bounded list kept sorted by distance - k is always small so insertion is fine
+type candidates struct {
+	size int
+	list []candidate
+}
+
+func (best *candidates) Full() bool {
+	return len(best.list) >= best.size
+}
+
+func (best *candidates) Worst() float64 {
+	return best.list[len(best.list)-1].distance
+}
+
+func (best *candidates) Push(point int, distance float64) {
+	if best.Full() && distance >= best.Worst() {
+		return
+	}
+
+	i := sort.Search(len(best.list), func(i int) bool { return best.list[i].distance > distance })
+	best.list = append(best.list, candidate{})
+	copy(best.list[i+1:], best.list[i:])
+	best.list[i] = candidate{point: point, distance: distance}
+
+	if len(best.list) > best.size {
+		best.list = best.list[:best.size]
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// the tree has to give the same answer as checking every point, for every metric
+func TestNearestMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	randomColor := func() color.Color {
+		return color.RGBA{R: uint8(rng.Intn(256)), G: uint8(rng.Intn(256)), B: uint8(rng.Intn(256)), A: 255}
+	}
+
+	for _, metric := range []ColorMetric{MetricRGB, MetricRedmean, MetricCIE76, MetricCIEDE2000} {
+		points := make([][]float64, 500)
+		for i := range points {
+			point := metric.ProjectColor(randomColor())
+			points[i] = point[:]
+		}
+		tree := buildColorTree(points, metric)
+
+		for query := 0; query < 1000; query++ {
+			projected := metric.ProjectColor(randomColor())
+			target := projected[:]
+
+			best := 0
+			for i := range points {
+				if metric.DescriptorDistance(target, points[i]) < metric.DescriptorDistance(target, points[best]) {
+					best = i
+				}
+			}
+
+			got := tree.Nearest(target, 1)[0]
+			if metric.DescriptorDistance(target, points[got]) > metric.DescriptorDistance(target, points[best]) {
+				t.Fatalf("metric %d: tree found %v, brute force found the closer %v", metric, points[got], points[best])
+			}
+		}
+	}
+}
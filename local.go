@@ -15,7 +15,13 @@ import (
 	"golang.org/x/image/draw"
 )
 
+// - reads it from stdin
 func OpenImage(fileName string) (image.Image, error) {
+	if fileName == stdio {
+		imageData, _, err := image.Decode(os.Stdin)
+		return imageData, err
+	}
+
 	file, err := os.Open(fileName)
 	if err != nil {
 		return nil, err
@@ -38,7 +44,7 @@ func ReadFolder(folderPath string, brandName string, imageSettings Settings) (*B
 		brandName = filepath.Base(folderPath)
 	}
 
-	brand := InitBrand(brandName)
+	brand := InitBrand(brandName, imageSettings)
 	fmt.Printf("Making emojikeg from images in %s\n", folderPath)
 
 	files, err := os.ReadDir(folderPath)
@@ -62,17 +68,23 @@ func ReadFolder(folderPath string, brandName string, imageSettings Settings) (*B
 		name := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
 
 		id := strings.Split(name, "__")
-		if len(id) == 2 {
+		if len(id) == 2 || len(id) == 3 {
 			var i int
 
 			i, err = strconv.Atoi(id[0])
 			if err == nil {
-				brand.emojis.Add(id[1], emoji, i)
+				added := brand.emojis.Add(id[1], emoji, i)
+
+				if len(id) == 3 {
+					if added.info.code, err = HexToCodepoints(id[2]); err != nil {
+						fmt.Printf("[warning] %s has malformed codepoints: %s\n", f.Name(), err)
+					}
+				}
 				continue
 			}
 		}
 
-		fmt.Printf("image successfully read but no id present in name => {[index]__[name]}")
+		fmt.Printf("image successfully read but no id present in name => {[index]__[name]{__[codepoints]}}")
 		brand.emojis.Add(name, emoji, -1)
 	}
 
@@ -87,11 +99,47 @@ func ReadCartridgeFromBytes(imageBytes []byte, brandName string, X int, Y int, i
 	}
 
 	fmt.Printf("Fetching brand %v from embed\n", brandName)
-	return ReadCartridge(img, brandName, X, Y, imageSettings)
+	return ReadCartridge(img, brandName, X, Y, imageSettings, nil)
+}
+
+var cartridgeNameReg = regexp.MustCompile(`(.*)-(\d*)x(\d*)$`)
+
+// the brand a cartridge holds without decoding it, same rules as ReadCartridgeFromFile
+func CartridgeBrand(fileName string) string {
+	if filepath.Ext(fileName) == ".zip" {
+		if archive, err := OpenArchive(fileName); err == nil {
+			defer archive.Close()
+			return archive.Manifest().Brand
+		}
+	} else if manifest, err := ReadManifest(ManifestPath(fileName)); err == nil && manifest != nil {
+		return manifest.Brand
+	}
+
+	name := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	if match := cartridgeNameReg.FindStringSubmatch(name); len(match) == 4 {
+		return match[1]
+	}
+	return name
 }
 
 func ReadCartridgeFromFile(fileName string, brandName string, X int, Y int, imageSettings Settings) (*Brand, error) {
-	test := regexp.MustCompile(`(.*)-(\d*)x(\d*)$`)
+	if filepath.Ext(fileName) == ".zip" {
+		return ReadArchive(fileName, brandName, imageSettings) // has its own manifest, dimensions included
+	}
+
+	manifest, err := ReadManifest(ManifestPath(fileName))
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest != nil {
+		if len(brandName) == 0 {
+			brandName = manifest.Brand
+		}
+		if X == 0 || Y == 0 {
+			X, Y = manifest.TileWidth, manifest.TileHeight
+		}
+	}
 
 	if len(brandName) == 0 {
 		brandName = filepath.Base(fileName)
@@ -100,7 +148,7 @@ func ReadCartridgeFromFile(fileName string, brandName string, X int, Y int, imag
 
 	if X == 0 || Y == 0 {
 
-		match := test.FindStringSubmatch(brandName)
+		match := cartridgeNameReg.FindStringSubmatch(brandName)
 
 		if len(match) != 4 { // [full, suffix, X, Y]
 			return nil, fmt.Errorf("no dimensions specified and failed to infer from name (must be suffixed with -XxY)")
@@ -128,17 +176,66 @@ func ReadCartridgeFromFile(fileName string, brandName string, X int, Y int, imag
 	}
 
 	fmt.Printf("Making emojikeg from %s\n", fileName)
-	return ReadCartridge(imageData, brandName, X, Y, imageSettings)
+	brand, err := ReadCartridge(imageData, brandName, X, Y, imageSettings, manifest)
+	if err != nil || manifest == nil || len(brand.emojis.list) == 0 {
+		return brand, err
+	}
+
+	// save whatever had to be worked out so the next load with these settings can skip it
+	key := NewDescriptorKey(brand.emojis.list[0].img.Bounds().Size(), imageSettings)
+	if manifest.FindDescriptors(key) == nil {
+		manifest.AddDescriptors(key, brand.emojis.list)
+		if err := manifest.Write(ManifestPath(fileName)); err != nil {
+			fmt.Printf("[warning] couldn't cache descriptors in %s: %s\n", ManifestPath(fileName), err)
+		}
+	}
+	return brand, nil
 }
 
-func ReadCartridge(imageData image.Image, brandName string, X int, Y int, imageSettings Settings) (*Brand, error) {
+// manifest can be nil, in which case every tile is read and blank ones are thrown away afterwards
+func ReadCartridge(imageData image.Image, brandName string, X int, Y int, imageSettings Settings, manifest *CartridgeManifest) (*Brand, error) {
 
 	emojiScalar, err := CreateScalar(image.Rectangle{Max: image.Point{X, Y}}, imageSettings.imageScale)
 	if err != nil {
 		return nil, err
 	}
 
-	brand := InitBrand(brandName)
+	brand := InitBrand(brandName, imageSettings)
+
+	if manifest != nil {
+		descriptors := manifest.FindDescriptors(NewDescriptorKey(emojiScalar.Size(), imageSettings))
+
+		// each tile is cut out at full size and scaled on its own, scaling the whole atlas first drifts off the tiles when X*escale isn't whole
+		tileSize := image.Rect(0, 0, X, Y)
+
+		for i, tile := range manifest.Tiles {
+			position := image.Point{(i % manifest.Columns) * X, (i / manifest.Columns) * Y}
+
+			original := image.NewRGBA(tileSize)
+			draw.Draw(original, tileSize, imageData, imageData.Bounds().Min.Add(position), draw.Src)
+
+			emoji := GetTransparent(imageSettings.backgroundColor, image.Rectangle{
+				image.Point{0, 0},
+				emojiScalar.Max,
+			})
+
+			draw.Draw(emoji, emojiScalar, Resize(original, emojiScalar), image.Point{0, 0}, draw.Over)
+
+			if descriptors == nil {
+				brand.emojis.Add(tile.Name, emoji, i).info = tile.Info()
+				continue
+			}
+
+			average, dominant, err := descriptors.Tile(i)
+			if err != nil {
+				return nil, err
+			}
+			brand.emojis.AddPrecomputed(tile.Name, emoji, i, average, dominant).info = tile.Info()
+		}
+
+		brand.emojis.Reindex() // info only went in after each emoji was indexed
+		return brand, nil
+	}
 
 	imageScalar, _ := CreateScalar(imageData, imageSettings.imageScale)
 	imageData = Resize(imageData, imageScalar)
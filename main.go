@@ -2,12 +2,16 @@ package main
 
 import (
 	_ "embed"
+	"errors"
+	"flag"
 	"fmt"
 	"image/color"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const seperator = "%"
@@ -40,21 +44,44 @@ type DstSettings struct {
 	mode, pathName          string
 	escale, iscale          float64
 	quality                 float64
+	metric                  ColorMetric
+	grid                    int
+	dither                  DitherMode
+	averaging               AverageMode
+	match                   string
+	dominantColors          int
+	penalty                 float64
+	radius, maxUse, topN    int
+	seed                    int64
+	seeded                  bool
+	quadtree                bool
+	maxTile                 int
+	threshold               float64
+	mix                     map[string]float64 // nil => pick a single brand
+	metadata                string
+	format                  string        // png/zip for cart, png/jpg for emojify (empty => from the extension or quality)
+	tones                   ToneSelection // nil => every skin tone
+	columns                 int           // text only
+	batch                   BatchSettings // emojify only, when the input is a folder or glob
 	inputImage, outputImage string
+	code                    string // variants only
+	brand                   string // name, alias, index (from 1) or all, empty => ask if there's more than one
 }
 
 type SrcSettings struct {
-	mode                string
-	modifiers           bool
-	dirNames, fileNames []string
+	mode   string
+	html   ScrapeSource
+	filter *BrandFilter // nil => every brand
+	paths  []string     // folders and cartridges, in the order they were given
 }
 
-func LoopPathList(paths []string) (filePaths, folderPaths []string) {
+// missingPaths are the ones that don't exist (or can't be opened)
+func LoopPathList(paths []string) (filePaths, folderPaths, missingPaths []string) {
 
 	for _, el := range paths {
 		nature, err := IsDir(el)
 		if err != nil {
-			// fmt.Printf("[warning] %s isn't a valid path: %s\n", el, err)
+			missingPaths = append(missingPaths, el)
 			continue
 		}
 		if nature {
@@ -64,7 +91,12 @@ func LoopPathList(paths []string) (filePaths, folderPaths []string) {
 		}
 	}
 
-	return filePaths, folderPaths
+	return filePaths, folderPaths, missingPaths
+}
+
+// text is just emojify with a different output
+func (settings *DstSettings) Emojifies() bool {
+	return settings.mode == "emojify" || settings.mode == "text"
 }
 
 func extractDst(cmds []string) *DstSettings {
@@ -76,15 +108,27 @@ func extractDst(cmds []string) *DstSettings {
 		cmds = append(cmds, "cart") // default value
 	}
 
-	if cmds[0] == "cart" || cmds[0] == "list" || cmds[0] == "emojify" {
+	if cmds[0] == "cart" || cmds[0] == "list" || cmds[0] == "emojify" || cmds[0] == "text" || cmds[0] == "variants" {
 		settings.mode = cmds[0]
 		cmds = cmds[1:]
 	} else {
-		fmt.Println("Didn't specify a mode - cart/list/emojify")
+		fmt.Println("Didn't specify a mode - cart/list/emojify/text/variants")
 		return nil
 	}
 
-	if settings.mode == "emojify" {
+	if settings.mode == "variants" {
+		if len(cmds) != 1 {
+			fmt.Println("for variants, specify one emoji - as itself, U+1F44B or 1f44b")
+			return nil
+		}
+		if settings.code, err = parseEmojiArg(cmds[0]); err != nil {
+			fmt.Printf("[error] %s\n", err)
+			return nil
+		}
+		return settings
+	}
+
+	{ // options, cart only cares about escale, format and cols
 		var x int
 
 		for i := range cmds {
@@ -98,19 +142,166 @@ func extractDst(cmds []string) *DstSettings {
 			name := option[0]
 			value := option[1]
 
-			if name != "escale" && name != "iscale" && name != "quality" { // bear with me
-				break
+			if name == "metric" {
+				if settings.metric, err = ParseMetric(value); err != nil {
+					fmt.Printf("[warning] %s specified but error resolving: %s", name, err)
+				}
+				continue
+			}
+
+			if name == "dither" {
+				if settings.dither, err = ParseDither(value); err != nil {
+					fmt.Printf("[warning] %s specified but error resolving: %s\n", name, err)
+				}
+				continue
+			}
+
+			if name == "average" {
+				if settings.averaging, err = ParseAverage(value); err != nil {
+					fmt.Printf("[warning] %s specified but error resolving: %s\n", name, err)
+				}
+				continue
+			}
+
+			if name == "match" {
+				if value != "average" && value != "dominant" {
+					fmt.Printf("[warning] %s can only be average/dominant - ignored\n", name)
+					continue
+				}
+				settings.match = value
+				continue
+			}
+
+			if name == "colors" {
+				if settings.dominantColors, err = strconv.Atoi(value); err != nil || settings.dominantColors < 1 {
+					fmt.Printf("[warning] %s must be a whole number above 0 - ignored\n", name)
+					settings.dominantColors = 0
+				}
+				continue
+			}
+
+			if name == "only" || name == "skip" {
+				if name == "only" {
+					settings.batch.only = append(settings.batch.only, strings.Split(value, ",")...)
+				} else {
+					settings.batch.skip = append(settings.batch.skip, strings.Split(value, ",")...)
+				}
+				continue
+			}
+
+			if name == "radius" || name == "maxuse" || name == "topn" || name == "maxtile" || name == "cols" || name == "jobs" {
+				var n int
+				if n, err = strconv.Atoi(value); err != nil || n < 1 {
+					fmt.Printf("[warning] %s must be a whole number above 0 - ignored\n", name)
+					continue
+				}
+
+				switch name {
+				case "radius":
+					settings.radius = n
+				case "maxuse":
+					settings.maxUse = n
+				case "topn":
+					settings.topN = n
+				case "maxtile":
+					settings.maxTile = n
+				case "cols":
+					settings.columns = n
+				case "jobs":
+					settings.batch.jobs = n
+				}
+				continue
+			}
+
+			if name == "layout" {
+				if value != "grid" && value != "quadtree" {
+					fmt.Printf("[warning] %s can only be grid/quadtree - ignored\n", name)
+					continue
+				}
+				settings.quadtree = value == "quadtree"
+				continue
+			}
+
+			if name == "mix" {
+				if settings.mix, err = parseMix(value); err != nil {
+					fmt.Printf("[warning] %s specified but error resolving: %s\n", name, err)
+				}
+				continue
+			}
+
+			if name == "format" {
+				if settings.Emojifies() && value != "png" && value != "jpg" {
+					fmt.Printf("[warning] %s can only be png/jpg when emojifying - ignored\n", name)
+					continue
+				}
+				if !settings.Emojifies() && value != "png" && value != "zip" {
+					fmt.Printf("[warning] %s can only be png/zip - ignored\n", name)
+					continue
+				}
+				settings.format = value
+				continue
+			}
+
+			if name == "tones" {
+				if settings.tones, err = ParseToneSelection(value); err != nil {
+					fmt.Printf("[warning] %s specified but error resolving: %s\n", name, err)
+				}
+				continue
+			}
+
+			if name == "brand" {
+				settings.brand = value
+				continue
+			}
+
+			if name == "meta" {
+				settings.metadata = value
+				continue
+			}
+
+			if name == "seed" {
+				if settings.seed, err = strconv.ParseInt(value, 10, 64); err != nil {
+					fmt.Printf("[warning] %s specified but error resolving: %s\n", name, err)
+					continue
+				}
+				settings.seeded = true
+				continue
+			}
+
+			if name == "grid" {
+				if settings.grid, err = strconv.Atoi(value); err != nil || settings.grid < 1 {
+					fmt.Printf("[warning] %s must be a whole number above 0 - ignored\n", name)
+					settings.grid = 0
+				}
+				continue
+			}
+
+			if name != "escale" && name != "scale" && name != "iscale" && name != "quality" && name != "penalty" && name != "threshold" { // bear with me
+				if _, err := os.Stat(cmds[i]); err != nil {
+					fmt.Printf("[error] unknown option %s\n", name)
+					return nil
+				}
+				break // a path with a colon in it
 			}
 
 			var scl float64
 			if scl, err = strconv.ParseFloat(value, 64); err == nil {
+				if (name == "escale" || name == "scale" || name == "iscale") && (scl <= 0 || scl > 1) {
+					fmt.Printf("[error] %s has to be in (0,1]: %v\n", name, scl)
+					return nil
+				}
+
 				switch name {
-				case "escale":
+				case "escale", "scale":
 					settings.escale = scl
 				case "iscale":
 					settings.iscale = scl
 				case "quality":
 					settings.quality = scl
+				case "penalty":
+					settings.penalty = scl
+				case "threshold":
+					settings.threshold = scl
 				}
 			} else {
 				fmt.Printf("[warning] %s specified but error resolving: %s", name, err)
@@ -120,9 +311,35 @@ func extractDst(cmds []string) *DstSettings {
 		cmds = cmds[x:]
 	}
 
-	filePaths, folderPaths := LoopPathList(cmds)
+	filePaths, folderPaths, _ := LoopPathList(cmds) // the output doesn't have to exist
+
+	if settings.Emojifies() && len(cmds) > 0 && cmds[0] == stdio { // the image comes in on stdin
+		if len(cmds) > 2 {
+			fmt.Println("for emojify/text mode, specify atleast an input image and at max a second path for output image/text")
+			return nil
+		}
+		if len(cmds) == 2 && cmds[1] != stdio {
+			if _, err := os.Stat(cmds[1]); err == nil {
+				fmt.Printf("[error] refuse to overwrite existing file: %s\n", cmds[1])
+				return nil
+			}
+		}
 
-	if settings.mode == "emojify" {
+		settings.inputImage = cmds[0]
+		if len(cmds) == 2 {
+			settings.outputImage = cmds[1]
+		}
+	} else if settings.Emojifies() && len(cmds) > 0 && IsBatchInput(cmds[0]) {
+		if settings.mode == "text" || len(cmds) > 2 || (len(cmds) == 2 && cmds[1] == stdio) {
+			fmt.Println("for a folder/glob of images, use emojify with at max a second path for the output folder")
+			return nil
+		}
+
+		settings.inputImage = cmds[0]
+		if len(cmds) == 2 {
+			settings.outputImage = cmds[1]
+		}
+	} else if settings.Emojifies() {
 		if len(cmds) == 0 || len(cmds) > 2 || len(filePaths) != 1 || len(folderPaths) > 0 {
 
 			if len(folderPaths) > 0 {
@@ -131,7 +348,10 @@ func extractDst(cmds []string) *DstSettings {
 			if len(filePaths) > 1 {
 				fmt.Printf("[error] refuse to overwrite existing file(s): %s\n", filePaths)
 			}
-			fmt.Println("for emojify mode, specify atleast an input image and at max a second path for output image")
+			if len(cmds) > 0 && len(filePaths) == 0 && len(folderPaths) == 0 {
+				fmt.Printf("[error] input image %s doesn't exist\n", cmds[0])
+			}
+			fmt.Println("for emojify/text mode, specify atleast an input image and at max a second path for output image/text")
 			return nil
 		}
 
@@ -159,9 +379,64 @@ func extractDst(cmds []string) *DstSettings {
 	return settings
 }
 
+// 👋, U+1F44B or 1f44b
+func parseEmojiArg(value string) (string, error) {
+	if strings.HasPrefix(strings.ToUpper(value), "U+") {
+		return ParseCodepoints(value)
+	}
+	if code, err := HexToCodepoints(value); err == nil {
+		return code, nil
+	}
+	return value, nil
+}
+
+// all => every brand equally, otherwise brand=weight pairs seperated by commas (a bare brand name means weight 1)
+func parseMix(value string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	if value == "all" {
+		return weights, nil
+	}
+
+	for _, el := range strings.Split(value, ",") {
+		pair := strings.Split(el, "=")
+		if len(pair) == 1 {
+			weights[pair[0]] = 1
+			continue
+		}
+
+		weight, err := strconv.ParseFloat(pair[1], 64)
+		if err != nil || len(pair) > 2 {
+			return nil, fmt.Errorf("malformed brand weight %s (should be brand=weight)", el)
+		}
+		weights[pair[0]] = weight
+	}
+	return weights, nil
+}
+
 func extractSrc(cmds []string) *SrcSettings {
 
-	settings := &SrcSettings{modifiers: true} // default settings
+	settings := &SrcSettings{html: DefaultScrapeSource()} // default settings
+
+	// brands:Apple,Google / exclude:Samsung can go with any source
+	var rest []string
+	for _, el := range cmds {
+		name, value, found := strings.Cut(el, ":")
+		if !found || (name != "brands" && name != "exclude") {
+			rest = append(rest, el)
+			continue
+		}
+
+		if settings.filter == nil {
+			settings.filter = &BrandFilter{}
+		}
+		if name == "brands" {
+			settings.filter.include = append(settings.filter.include, strings.Split(value, ",")...)
+		} else {
+			settings.filter.exclude = append(settings.filter.exclude, strings.Split(value, ",")...)
+		}
+	}
+	cmds = rest
+
 	if len(cmds) == 0 || (len(cmds) == 1 && cmds[0] == "internal") {
 		settings.mode = "internal" // don't set it in struct init as then it won't fail when incorrect stuff is specified
 		return settings
@@ -175,171 +450,342 @@ func extractSrc(cmds []string) *SrcSettings {
 		}
 		settings.mode = "html"
 
-		if split := strings.Split(cmds[0], ":"); len(split) > 1 {
-
-			if split[1] == "0" {
-				settings.modifiers = false
-			} else {
-				fmt.Println("[error] html modifier deactivation specified but malformed (should be 0)")
-				return nil
+		// html:0,file=./charts,url=https://...,test=./emoji-test.txt
+		if split := strings.SplitN(cmds[0], ":", 2); len(split) > 1 {
+
+			for _, option := range strings.Split(split[1], ",") {
+				pair := strings.SplitN(option, "=", 2)
+
+				switch {
+				case option == "0":
+					settings.html.modifiers = false
+				case option == "strict":
+					settings.html.strict = true
+				case pair[0] == "strict":
+					var err error
+					if settings.html.maxErrors, err = strconv.Atoi(pair[1]); err != nil || settings.html.maxErrors < 0 {
+						fmt.Printf("[error] html strict must be a whole number of errors to allow\n")
+						return nil
+					}
+					settings.html.strict = true
+				case len(pair) != 2 || len(pair[1]) == 0:
+					fmt.Printf("[error] html option %s is malformed (should be 0, strict or name=value)\n", option)
+					return nil
+				case pair[0] == "file":
+					settings.html.files = append(settings.html.files, pair[1])
+				case pair[0] == "url":
+					settings.html.chartsURL = pair[1]
+				case pair[0] == "test":
+					settings.html.emojiTest = pair[1]
+				case pair[0] == "timeout":
+					var err error
+					if settings.html.timeout, err = time.ParseDuration(pair[1]); err != nil || settings.html.timeout <= 0 {
+						fmt.Printf("[error] html timeout must be a duration like 30s or 2m\n")
+						return nil
+					}
+				case pair[0] == "retries":
+					var err error
+					if settings.html.retries, err = strconv.Atoi(pair[1]); err != nil || settings.html.retries < 0 {
+						fmt.Printf("[error] html retries must be a whole number\n")
+						return nil
+					}
+				case pair[0] == "cache":
+					settings.html.cacheDir = pair[1]
+					if pair[1] == "off" {
+						settings.html.cacheDir = ""
+					}
+				default:
+					fmt.Printf("[error] unknown html option %s\n", pair[0])
+					return nil
+				}
 			}
 		}
 
 		return settings
 	}
 
-	filePaths, folderPaths := LoopPathList(cmds)
-	if len(folderPaths) == 0 && len(filePaths) == 0 {
-		fmt.Println("no valid files/folders specified")
+	_, _, missingPaths := LoopPathList(cmds)
+	if len(missingPaths) > 0 {
+		fmt.Printf("[error] these don't exist: %s\n", strings.Join(missingPaths, ", "))
 		return nil
 	}
-	settings.fileNames = filePaths
-	settings.dirNames = folderPaths
+	settings.paths = cmds
 
 	return settings
 }
 
 func main() {
+	if err := run(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0) // asked for help, already printed
+		}
+		if !errors.Is(err, errUsage) {
+			fmt.Printf("[error] %s\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// the usage has already been printed, nothing else to say
+var errUsage = errors.New("usage")
+
+const legacyUsage = "For scraping: \n{folderNames... cartridgeFiles... {brands:name,... exclude:name,...} html{:0 - exclude modifers,file=saved page or folder,url=charts base url,test=emoji-test.txt,timeout=2m,retries=3,cache=folder/off,strict{=max errors}} internal} " + seperator + " {[cart/list] {escale:int} {format:png/zip (cart only)} {cols:int (cart only, emojis per row)} {folderName}}\n\nFor emojifying: \n{...} % {emojify {escale:int (emoji scale)} {iscale:int (image scale)} {quality:int} {metric:rgb/redmean/cie76/ciede2000} {grid:int (match on an NxN grid)} {dither:none/fs/atkinson/bayer} {average:linear/alpha/legacy} {match:average/dominant} {colors:int (dominant colours per emoji)} {penalty:float} {radius:int} {maxuse:int} {topn:int} {seed:int} {layout:grid/quadtree} {maxtile:int} {threshold:float} {brand:name/index/all} {mix:all/brand=weight,...} {tones:all/base/light/medium-light/medium/medium-dark/dark,...} {meta:file.json} {format:png/jpg} [Source image - or - for stdin] {target image - or - for stdout}}\n\nFor emojifying a batch: \n{...} % {emojify {...emojify options} {only:glob,...} {skip:glob,...} {jobs:int (images at once)} [Source folder or quoted glob] {target folder - emojified by default}}\n\nFor skin tone variants: \n{...} % variants [emoji - itself, U+1F44B or 1f44b]\n\nFor emoji text: \n{...} % {text {cols:int (max emojis per line)} {...emojify options} [Source image - or - for stdin] {target text file - stdout if left out}}\n\nensure cartridge files have dimensions at the end of their name as (-XxY) or a .json manifest next to them (zip cartridges carry their own)\n*curly braces indicate optional inputs\n\nThis is the old syntax, run with help to see the subcommands"
+
+// the original src % dst syntax, kept so old scripts still work
+func runLegacy(args []string) error {
 
-	var err error
 	var src, dst []string
 
 	var sepI int
 
-	for i, el := range os.Args {
+	for i, el := range args {
 		if el == seperator {
-			src = os.Args[1:i]
-			dst = os.Args[i+1:]
-			sepI = i // my first hacky solution, is this the end?
+			src = args[:i]
+			dst = args[i+1:]
+			sepI = i + 1 // my first hacky solution, is this the end?
 			break
 		}
 	}
 
 	if len(dst) == 0 && sepI == 0 { // check for sepI ensures '%' isn't present
-		src = os.Args[1:] // not a mistake, dst comes after the %
+		src = args // not a mistake, dst comes after the %
 	}
 
 	dstSettings := extractDst(dst)
 	srcSettings := extractSrc(src)
 
-	if len(os.Args) <= 1 || srcSettings == nil || dstSettings == nil {
-		fmt.Println("For scraping: \n{folderNames... cartridgeFiles... html{:0 - exclude modifers} internal} " + seperator + " {[cart/list] {scale:int} {folderName}}\n\nFor emojifying: \n{...} % {emojify {escale:int (emoji scale)} {iscale:int (image scale)} {quality:int} [Source image] {target image}}\n\nensure cartridge files have dimensions at the end of their name as (-XxY)\n*curly braces indicate optional inputs")
+	if len(args) == 0 || srcSettings == nil || dstSettings == nil {
+		fmt.Println(legacyUsage)
 		fmt.Printf("\n")
 
-		os.Exit(-1)
+		return errUsage
+	}
+	dstSettings.ClaimStdout()
+
+	emojis, err := srcSettings.Load(dstSettings.ImageSettings())
+	if err != nil {
+		return err
+	}
+	return dstSettings.Run(emojis)
+}
+
+// when the output is stdout (-, or text without one) everything else that gets printed goes to stderr so it doesn't end up mixed into it
+func (dstSettings *DstSettings) ClaimStdout() {
+	if dstSettings.outputImage == stdio || (dstSettings.mode == "text" && len(dstSettings.outputImage) == 0) {
+		os.Stdout = os.Stderr
 	}
+}
 
-	imageSettings := Settings{imageScale: dstSettings.escale}
-	if dstSettings.mode == "emojify" {
+// what emojis have to be loaded with for this destination
+func (dstSettings *DstSettings) ImageSettings() Settings {
+	imageSettings := Settings{imageScale: dstSettings.escale, averaging: dstSettings.averaging}
+	if dstSettings.match == "dominant" {
+		imageSettings.dominantColors = dstSettings.dominantColors
+		if imageSettings.dominantColors == 0 {
+			imageSettings.dominantColors = defaultDominantColors
+		}
+	}
+	if dstSettings.Emojifies() {
 		imageSettings.backgroundColor = color.RGBA{A: 255}
 	}
+	return imageSettings
+}
+
+func (srcSettings *SrcSettings) Load(imageSettings Settings) (EmojiKeg, error) {
 
 	var emojis EmojiKeg
 
 	if srcSettings.mode == "internal" {
+		if !srcSettings.filter.Allows(internalBrandName) {
+			return nil, fmt.Errorf("the internal cartridge is %s which is filtered out", internalBrandName)
+		}
+
 		internalBrand, err := ReadCartridgeFromBytes(internalBrandBytes, internalBrandName, internalBrandX, internalBrandY, imageSettings)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		emojis = append(emojis, internalBrand)
 
 	} else if srcSettings.mode == "html" {
-		results, err := Scrape(srcSettings.modifiers, imageSettings)
+		results, err := Scrape(srcSettings.html, srcSettings.filter, imageSettings)
 
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		if results.total == 0 {
-			panic(fmt.Errorf("no emojis?"))
+			return nil, fmt.Errorf("no emojis?")
 		}
 
 		emojis = results.emojiStore
-
 	} else {
 		var wg sync.WaitGroup
 
-		for _, folderPath := range srcSettings.dirNames {
-			wg.Add(1)
-
-			go func(folderPath string) {
+		// each path gets its own slot so brands come out in the order they were given (brand:2 has to mean the same thing every run)
+		loaded := make([]*Brand, len(srcSettings.paths))
 
-				brand, err := ReadFolder(folderPath, "", imageSettings) // allow custom names for each path
-				if err != nil {
-					fmt.Println(err)
-					return
-				}
-				emojis = append(emojis, brand)
-
-				wg.Done()
-			}(folderPath)
-		}
+		for slot, sourcePath := range srcSettings.paths {
+			folder, err := IsDir(sourcePath)
+			if err != nil {
+				return nil, err
+			}
 
-		for _, cartridgePath := range srcSettings.fileNames {
+			if folder && !srcSettings.filter.Allows(filepath.Base(sourcePath)) {
+				continue
+			}
+			if !folder && filepath.Ext(sourcePath) == ".json" {
+				continue // manifest, gets picked up with its cartridge
+			}
+			if !folder && srcSettings.filter != nil && !srcSettings.filter.Allows(CartridgeBrand(sourcePath)) { // reads the manifest, so only when it matters
+				continue
+			}
 			wg.Add(1)
 
-			go func(cartridgePath string) {
+			go func(slot int, sourcePath string, folder bool) {
+				defer wg.Done()
 
-				brand, err := ReadCartridgeFromFile(cartridgePath, "", 0, 0, imageSettings)
+				var brand *Brand
+				var err error
+				if folder {
+					brand, err = ReadFolder(sourcePath, "", imageSettings) // allow custom names for each path
+				} else {
+					brand, err = ReadCartridgeFromFile(sourcePath, "", 0, 0, imageSettings)
+				}
 				if err != nil {
 					fmt.Println(err)
 					return
 				}
-				emojis = append(emojis, brand)
-
-				wg.Done()
-			}(cartridgePath)
+				loaded[slot] = brand
+			}(slot, sourcePath, folder)
 		}
 		wg.Wait()
 
+		for _, brand := range loaded {
+			if brand != nil {
+				emojis = append(emojis, brand)
+			}
+		}
+
 		if len(emojis) == 0 {
-			panic(fmt.Errorf("no emojis found in folders/cartridges"))
+			return nil, fmt.Errorf("no emojis found in folders/cartridges")
 		}
 	}
 
-	if dstSettings.mode == "emojify" {
+	return emojis, nil
+}
+
+func (dstSettings *DstSettings) Run(emojis EmojiKeg) (err error) {
 
-		var brand *Brand
+	if dstSettings.Emojifies() {
 
-		if len(emojis) > 1 {
+		var brand *Brand
+		var all EmojiKeg // brand:all, one output each
 
-			brandIndex := []*Brand{}
-			input := "\n\nSelect brand:\n"
+		if dstSettings.mix != nil {
+			if brand, err = emojis.Merge(dstSettings.mix); err != nil {
+				return err
+			}
+			fmt.Printf("Mixing %s\n", brand)
 
-			for i, brand := range emojis {
-				name := brand.String()
-				brandIndex = append(brandIndex, brand)
-				input += fmt.Sprintf("%v. %v\n", i+1, name)
+		} else if dstSettings.brand == "all" {
+			if dstSettings.mode == "text" {
+				return fmt.Errorf("brand:all only works with emojify")
+			}
+			if dstSettings.outputImage == stdio {
+				return fmt.Errorf("brand:all makes an image per brand, they can't all go to stdout")
 			}
 
-			fmt.Println(input)
-			var i int
-			for {
-				fmt.Printf("Input a number [%d,% d] - ", 1, len(brandIndex))
-				fmt.Scan(&i)
-				if i > 0 && i <= len(brandIndex) {
-					i--
-					break
+			for _, el := range emojis {
+				if el, err = el.WithTones(dstSettings.tones); err != nil {
+					return err
 				}
+				all = append(all, el)
 			}
 
-			brand = brandIndex[i]
+		} else if len(dstSettings.brand) > 0 {
+			if brand, err = emojis.Select(dstSettings.brand); err != nil {
+				return err
+			}
+
+		} else if len(emojis) > 1 {
+			if brand, err = emojis.Pick(); err != nil {
+				return err
+			}
 
 		} else {
 			brand = emojis[0]
 		}
 
-		err = brand.Emojify(dstSettings.inputImage, dstSettings.outputImage, dstSettings.iscale, dstSettings.quality)
+		if brand != nil {
+			if brand, err = brand.WithTones(dstSettings.tones); err != nil {
+				return err
+			}
+		}
 
-		if err == nil {
-			fmt.Printf("\nEmojification complete!\n")
+		convertSettings := ConvertSettings{
+			imageScale: dstSettings.iscale,
+			metric:     dstSettings.metric,
+			gridSize:   dstSettings.grid,
+			dither:     dstSettings.dither,
+			dominant:   dstSettings.match == "dominant",
+			penalty:    dstSettings.penalty,
+			radius:     dstSettings.radius,
+			maxUse:     dstSettings.maxUse,
+			topN:       dstSettings.topN,
+			seed:       dstSettings.seed,
+			seeded:     dstSettings.seeded,
+			quadtree:   dstSettings.quadtree,
+			maxTile:    dstSettings.maxTile,
+			threshold:  dstSettings.threshold,
+			metadata:   dstSettings.metadata,
+			format:     dstSettings.format,
+		}
+
+		if IsBatchInput(dstSettings.inputImage) {
+			if all == nil {
+				all = EmojiKeg{brand}
+			}
+			return dstSettings.runBatch(all, convertSettings)
+		}
+
+		if all != nil {
+			err = all.Emojify(dstSettings.inputImage, dstSettings.outputImage, convertSettings, dstSettings.quality)
+
+			if err == nil {
+				fmt.Printf("\nEmojification complete!\n")
+			}
+		} else if dstSettings.mode == "text" {
+			err = brand.EmojifyText(dstSettings.inputImage, dstSettings.outputImage, dstSettings.columns, convertSettings)
+		} else {
+			err = brand.Emojify(dstSettings.inputImage, dstSettings.outputImage, convertSettings, dstSettings.quality)
+
+			if err == nil {
+				fmt.Printf("\nEmojification complete!\n")
+			}
+		}
+	} else if dstSettings.mode == "variants" {
+		for _, brand := range emojis {
+			variants := brand.emojis.Variants(dstSettings.code)
+			fmt.Printf("\n%s - %d variants\n", brand.name, len(variants))
+
+			if unknown := brand.UnknownTones(); len(variants) == 0 && unknown > 0 {
+				fmt.Printf("[warning] %d of %s's emojis have no codepoints so their variants can't be found\n", unknown, brand.name)
+			}
+
+			for _, emoji := range variants {
+				tones := emoji.Tones()
+				if len(tones) == 0 {
+					tones = []SkinTone{ToneNone}
+				}
+				fmt.Printf("%s\t%s\t%v\n", emoji.info.code, emoji.name, tones)
+			}
 		}
 	} else {
 		fmt.Printf("\n%s", emojis)
 
 		switch dstSettings.mode {
 		case "cart":
-			err = emojis.Export(dstSettings.pathName)
+			err = emojis.Export(dstSettings.pathName, dstSettings.format, dstSettings.columns)
 		case "list":
 			err = emojis.Chunky(dstSettings.pathName)
 		}
@@ -349,7 +795,5 @@ func main() {
 		}
 	}
 
-	if err != nil {
-		panic(err)
-	}
+	return err
 }
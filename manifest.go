@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// bump whenever the manifest changes in a way older readers would get wrong
+// 2 - descriptors
+const cartridgeVersion = 2
+
+// how many sets of descriptors a manifest keeps, oldest go first
+const maxDescriptorSets = 8
+
+/*
+sidecar written next to every cartridge (Apple-72x72.png -> Apple-72x72.json)
+without it a cartridge is just a picture - emoji names come back as their index and the grid has to be guessed
+*/
+type CartridgeManifest struct {
+	Version    int            `json:"version"`
+	Brand      string         `json:"brand"`
+	TileWidth  int            `json:"tileWidth"`
+	TileHeight int            `json:"tileHeight"`
+	Columns    int            `json:"columns"`
+	Rows       int            `json:"rows"`
+	Count      int            `json:"count"`
+	Tiles      []ManifestTile `json:"tiles"` // in the same order as the cartridge, row by row
+
+	// averages (and dominant colours) already worked out for the settings in their key, saves redoing them on every load
+	Descriptors []DescriptorSet `json:"descriptors,omitempty"`
+}
+
+// anything the descriptors depend on - if one of these differs they have to be worked out again
+type DescriptorKey struct {
+	TileWidth      int    `json:"tileWidth"` // after scaling
+	TileHeight     int    `json:"tileHeight"`
+	Background     string `json:"background"` // #rrggbbaa
+	Averaging      int    `json:"averaging"`
+	DominantColors int    `json:"dominantColors"`
+}
+
+type DescriptorSet struct {
+	Key      DescriptorKey        `json:"key"`
+	Averages []string             `json:"averages"`           // one per tile
+	Dominant [][]ManifestDominant `json:"dominant,omitempty"` // one list per tile, only when DominantColors > 0
+}
+
+type ManifestDominant struct {
+	Color    string  `json:"color"`
+	Coverage float64 `json:"coverage"`
+}
+
+func NewDescriptorKey(tileSize image.Point, imageSettings Settings) DescriptorKey {
+	background := imageSettings.backgroundColor
+	return DescriptorKey{
+		TileWidth:      tileSize.X,
+		TileHeight:     tileSize.Y,
+		Background:     fmt.Sprintf("#%02x%02x%02x%02x", background.R, background.G, background.B, background.A),
+		Averaging:      int(imageSettings.averaging),
+		DominantColors: imageSettings.dominantColors,
+	}
+}
+
+func (manifest *CartridgeManifest) FindDescriptors(key DescriptorKey) *DescriptorSet {
+	for i := range manifest.Descriptors {
+		set := &manifest.Descriptors[i]
+		if set.Key == key && len(set.Averages) == manifest.Count && (key.DominantColors == 0 || len(set.Dominant) == manifest.Count) && set.valid() {
+			return set
+		}
+	}
+	return nil
+}
+
+// remembers the descriptors of emojis that were just read from (or written to) this manifest's cartridge, in tile order
+func (manifest *CartridgeManifest) AddDescriptors(key DescriptorKey, emojis []*Emoji) {
+	set := DescriptorSet{Key: key}
+
+	for _, emoji := range emojis {
+		set.Averages = append(set.Averages, hexColor(emoji.average))
+
+		if key.DominantColors > 0 {
+			var dominant []ManifestDominant
+			for _, el := range emoji.dominant {
+				dominant = append(dominant, ManifestDominant{Color: hexColor(el.col), Coverage: el.coverage})
+			}
+			set.Dominant = append(set.Dominant, dominant)
+		}
+	}
+
+	// replaces a set with the same key that couldn't be used
+	var kept []DescriptorSet
+	for _, el := range manifest.Descriptors {
+		if el.Key != key {
+			kept = append(kept, el)
+		}
+	}
+	manifest.Descriptors = append(kept, set)
+	if len(manifest.Descriptors) > maxDescriptorSets {
+		manifest.Descriptors = manifest.Descriptors[len(manifest.Descriptors)-maxDescriptorSets:]
+	}
+}
+
+// every colour in it parses, a set that doesn't is skipped like it isn't there
+func (set *DescriptorSet) valid() bool {
+	for i := range set.Averages {
+		if _, _, err := set.Tile(i); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// the average and dominant colours of tile i
+func (set *DescriptorSet) Tile(i int) (color.Color, []DominantColor, error) {
+	average, err := parseHexColor(set.Averages[i])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var dominant []DominantColor
+	if set.Key.DominantColors > 0 {
+		for _, el := range set.Dominant[i] {
+			col, err := parseHexColor(el.Color)
+			if err != nil {
+				return nil, nil, err
+			}
+			dominant = append(dominant, DominantColor{col: col, coverage: el.Coverage})
+		}
+	}
+
+	return average, dominant, nil
+}
+
+func hexColor(col color.Color) string {
+	basic := ColorToBasic(col)
+	return fmt.Sprintf("#%02x%02x%02x", basic[0], basic[1], basic[2])
+}
+
+func parseHexColor(hex string) (color.RGBA, error) {
+	col := color.RGBA{A: 255}
+	if _, err := fmt.Sscanf(hex, "#%02x%02x%02x", &col.R, &col.G, &col.B); err != nil {
+		return col, fmt.Errorf("malformed colour %s", hex)
+	}
+	return col, nil
+}
+
+type ManifestTile struct {
+	Name     string `json:"name"`
+	Code     string `json:"code,omitempty"` // the characters themselves
+	Group    string `json:"group,omitempty"`
+	Subgroup string `json:"subgroup,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Status   string `json:"status,omitempty"`
+	Average  string `json:"average"` // #rrggbb
+}
+
+func ManifestPath(cartridgePath string) string {
+	return strings.TrimSuffix(cartridgePath, filepath.Ext(cartridgePath)) + ".json"
+}
+
+func NewManifestTile(emoji *Emoji) ManifestTile {
+	return ManifestTile{
+		Name:     emoji.name,
+		Code:     emoji.info.code,
+		Group:    emoji.info.group,
+		Subgroup: emoji.info.subgroup,
+		Version:  emoji.info.version,
+		Status:   emoji.info.status,
+		Average:  hexColor(emoji.average),
+	}
+}
+
+func (tile ManifestTile) Info() EmojiInfo {
+	return EmojiInfo{code: tile.Code, group: tile.Group, subgroup: tile.Subgroup, version: tile.Version, status: tile.Status}
+}
+
+// written next to it first and renamed over, so being interrupted (or not allowed to write) never leaves a broken manifest behind
+func (manifest *CartridgeManifest) Write(fileName string) error {
+	out, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name()) // no-op once it's been renamed
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "\t")
+	err = encoder.Encode(manifest)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(out.Name(), 0644); err != nil { // CreateTemp makes it 0600
+		return err
+	}
+	return os.Rename(out.Name(), fileName)
+}
+
+// descriptors are only a cache, if they can't be read they're dropped instead of failing the whole manifest
+func (manifest *CartridgeManifest) UnmarshalJSON(data []byte) error {
+	type fields CartridgeManifest // without this method, otherwise it'd call itself
+	raw := struct {
+		*fields
+		Descriptors json.RawMessage `json:"descriptors"`
+	}{fields: (*fields)(manifest)}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	manifest.Descriptors = nil
+	if len(raw.Descriptors) > 0 {
+		if err := json.Unmarshal(raw.Descriptors, &manifest.Descriptors); err != nil {
+			fmt.Printf("[warning] ignoring cached descriptors that can't be read: %s\n", err)
+			manifest.Descriptors = nil
+		}
+	}
+	return nil
+}
+
+// nil, nil => there's no manifest, fall back to the file name
+func ReadManifest(fileName string) (*CartridgeManifest, error) {
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	manifest := &CartridgeManifest{}
+	if err := json.NewDecoder(file).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("malformed manifest %s: %s", fileName, err)
+	}
+
+	if manifest.Version > cartridgeVersion {
+		return nil, fmt.Errorf("manifest %s is version %d but only up to %d is understood", fileName, manifest.Version, cartridgeVersion)
+	}
+	if manifest.TileWidth <= 0 || manifest.TileHeight <= 0 || manifest.Columns <= 0 || len(manifest.Tiles) != manifest.Count {
+		return nil, fmt.Errorf("manifest %s doesn't describe a valid grid", fileName)
+	}
+
+	return manifest, nil
+}
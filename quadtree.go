@@ -0,0 +1,120 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// defaults for the quadtree layout
+const defaultMaxTile = 8
+const defaultThreshold = 12
+
+// square of size x size mosaic cells, top left at x, y
+type quadBlock struct {
+	x, y, size int
+}
+
+/*
+tiles the mosaic with blocks of maxTile cells then keeps quartering any block whose colours vary too much
+flat areas end up as one big emoji, detailed ones go all the way down to one emoji per cell
+source has gridSize x gridSize pixels per cell, same as ConvertImage
+*/
+func QuadtreeLeaves(source *image.RGBA, gridSize, columns, rows, maxTile int, threshold float64) []quadBlock {
+
+	// has to be a power of two so blocks split down cleanly to single cells
+	size := 1
+	for size*2 <= maxTile {
+		size *= 2
+	}
+
+	var leaves []quadBlock
+	var split func(block quadBlock)
+
+	split = func(block quadBlock) {
+		if block.x >= columns || block.y >= rows {
+			return
+		}
+
+		overhangs := block.x+block.size > columns || block.y+block.size > rows
+		if block.size > 1 && (overhangs || block.Deviation(source, gridSize) > threshold) {
+			half := block.size / 2
+			split(quadBlock{block.x, block.y, half})
+			split(quadBlock{block.x + half, block.y, half})
+			split(quadBlock{block.x, block.y + half, half})
+			split(quadBlock{block.x + half, block.y + half, half})
+			return
+		}
+
+		leaves = append(leaves, block)
+	}
+
+	for y := 0; y < rows; y += size {
+		for x := 0; x < columns; x += size {
+			split(quadBlock{x, y, size})
+		}
+	}
+	return leaves
+}
+
+func (block quadBlock) pixels(gridSize int) image.Rectangle {
+	return image.Rect(block.x*gridSize, block.y*gridSize, (block.x+block.size)*gridSize, (block.y+block.size)*gridSize)
+}
+
+// standard deviation of the block's pixels (averaged over r, g, b) in 8-bit sRGB
+func (block quadBlock) Deviation(source *image.RGBA, gridSize int) float64 {
+	var sum, squares [3]float64
+	var count float64
+
+	region := block.pixels(gridSize)
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		for x := region.Min.X; x < region.Max.X; x++ {
+			offset := source.PixOffset(x, y)
+			for c := 0; c < 3; c++ {
+				value := float64(source.Pix[offset+c])
+				sum[c] += value
+				squares[c] += value * value
+			}
+			count++
+		}
+	}
+
+	var variance float64
+	for c := range sum {
+		mean := sum[c] / count
+		variance += squares[c]/count - mean*mean
+	}
+	return math.Sqrt(math.Max(0, variance/3))
+}
+
+// the block boiled down to gridSize x gridSize colours, ready for Describe - also returns them as a cache key
+func (block quadBlock) Sample(source *image.RGBA, gridSize int) ([]color.Color, []byte) {
+	cell := make([]color.Color, gridSize*gridSize)
+	var key []byte
+
+	region := block.pixels(gridSize)
+	for i := range cell {
+		// each colour of the grid covers block.size x block.size source pixels
+		originX := region.Min.X + (i%gridSize)*block.size
+		originY := region.Min.Y + (i/gridSize)*block.size
+
+		sum := make([]int, 4)
+		for y := originY; y < originY+block.size; y++ {
+			for x := originX; x < originX+block.size; x++ {
+				offset := source.PixOffset(x, y)
+				for c := range sum {
+					sum[c] += int(source.Pix[offset+c])
+				}
+			}
+		}
+
+		basic := make([]uint8, 4)
+		for c := range sum {
+			basic[c] = uint8(math.Round(float64(sum[c]) / float64(block.size*block.size)))
+		}
+
+		cell[i] = BasicToColor(basic)
+		key = append(key, basic...)
+	}
+	return cell, key
+}
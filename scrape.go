@@ -1,36 +1,76 @@
 package main
 
 import (
+	"bufio"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"image"
 	_ "image/gif" // for the purpose of this program we only care about the first frame which is what we get
 	_ "image/png"
-	"net/http"
+	"io"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 )
 
 var b64Reg *regexp.Regexp
 var experimentalReg *regexp.Regexp
+var chartsReleaseReg *regexp.Regexp
 
 func init() {
 
 	b64Reg, _ = regexp.Compile(".*base64,(.*)")
 	experimentalReg, _ = regexp.Compile(`\[(.*)\].*`)
+	chartsReleaseReg, _ = regexp.Compile(`^(https?://[^/]+)/emoji/charts-([0-9.]+)/?$`) // https://unicode.org/emoji/charts-15.0 => https://unicode.org, 15.0
+}
+
+// what went wrong with a row, so a summary can tell markup changes apart from the odd broken image
+type ScrapeErrorKind string
+
+const (
+	ErrCodepoints   ScrapeErrorKind = "codepoints"    // .code column couldn't be read
+	ErrMalformedRow ScrapeErrorKind = "malformed row" // wrong number of columns
+	ErrImage        ScrapeErrorKind = "image"         // missing/broken base64 image
+	ErrTitle        ScrapeErrorKind = "title"         // experimental row image without a [brand] title
+	ErrUnknownBrand ScrapeErrorKind = "unknown brand" // experimental row image for a brand the header doesn't have
+)
+
+type ScrapeError struct {
+	kind  ScrapeErrorKind
+	row   int // in the page's table
+	name  string
+	brand string // empty => the row as a whole
+	err   error
+}
+
+func (scrapeError *ScrapeError) Error() string {
+	where := fmt.Sprintf("row %d (%s)", scrapeError.row, scrapeError.name)
+	if len(scrapeError.brand) > 0 {
+		where += " for " + scrapeError.brand
+	}
+	return fmt.Sprintf("%s: %s - %s", scrapeError.kind, where, scrapeError.err)
+}
+
+func (scrapeError *ScrapeError) Unwrap() error {
+	return scrapeError.err
 }
 
 type ScrapedResult struct {
-	total         int
-	errors        []error
+	total         int64   // bumped from every row's goroutine so only touch it with atomic
+	errors        []error // *ScrapeError
 	emojiStore    EmojiKeg
 	imageSettings Settings
+	filter        *BrandFilter // columns it doesn't allow are never decoded
 }
 
-func (scraped *ScrapedResult) Store(s *goquery.Selection, name string, imageOrder int, brandIndex int) error {
+func (scraped *ScrapedResult) Store(s *goquery.Selection, name string, info EmojiInfo, imageOrder int, brandIndex int) error {
 
 	src, state := s.Attr("src")
 	if !state {
@@ -55,12 +95,37 @@ func (scraped *ScrapedResult) Store(s *goquery.Selection, name string, imageOrde
 	}
 
 	scraped.emojiStore[brandIndex].mu.Lock()
-	scraped.emojiStore[brandIndex].emojis.Add(name, img, imageOrder)
+	scraped.emojiStore[brandIndex].emojis.Add(name, img, imageOrder).info = info
 	scraped.emojiStore[brandIndex].mu.Unlock()
 
 	return nil
 }
 
+// how many errors of each kind, e.g. "image: 3, malformed row: 1"
+func (scraped ScrapedResult) Summary() string {
+	counts := make(map[ScrapeErrorKind]int)
+	var kinds []ScrapeErrorKind
+
+	for _, err := range scraped.errors {
+		kind := ScrapeErrorKind("other")
+		var scrapeError *ScrapeError
+		if errors.As(err, &scrapeError) {
+			kind = scrapeError.kind
+		}
+
+		if counts[kind] == 0 {
+			kinds = append(kinds, kind)
+		}
+		counts[kind]++
+	}
+
+	var summary []string
+	for _, kind := range kinds {
+		summary = append(summary, fmt.Sprintf("%s: %d", kind, counts[kind]))
+	}
+	return strings.Join(summary, ", ")
+}
+
 func (scraped ScrapedResult) getIndex(name string) int {
 	for i, el := range scraped.emojiStore {
 		if el.name == name {
@@ -82,18 +147,35 @@ func (scrapedResult *ScrapedResult) AddFromDOM(dom *goquery.Document) (err error
 		return fmt.Errorf("no brandnames found")
 	}
 
-	relativeTranslation := make(map[int]int) // maps brandNames to master EmojiKeg
+	relativeTranslation := make(map[int]int) // maps brandNames to master EmojiKeg, -1 => filtered out
 	for i, name := range brandNames {
 
-		if scrapedResult.getIndex(name) > -1 {
-			relativeTranslation[i] = i
+		if !scrapedResult.filter.Allows(name) {
+			relativeTranslation[i] = -1
+			continue
+		}
+
+		if index := scrapedResult.getIndex(name); index > -1 {
+			relativeTranslation[i] = index
 			continue
 		}
 
-		scrapedResult.emojiStore = append(scrapedResult.emojiStore, InitBrand(name))
+		scrapedResult.emojiStore = append(scrapedResult.emojiStore, InitBrand(name, scrapedResult.imageSettings))
 		relativeTranslation[i] = len(scrapedResult.emojiStore) - 1
 	}
 
+	// group and subgroup are header rows above the emojis so they have to be worked out in order, before the goroutines start
+	headings := make([]EmojiInfo, table.Length())
+	var heading EmojiInfo
+	table.Each(func(i int, s *goquery.Selection) {
+		if group := s.Find(".bighead"); group.Length() > 0 {
+			heading = EmojiInfo{group: strings.TrimSpace(group.Text())}
+		} else if subgroup := s.Find(".mediumhead"); subgroup.Length() > 0 {
+			heading.subgroup = strings.TrimSpace(subgroup.Text())
+		}
+		headings[i] = heading
+	})
+
 	old := make([]int, len(scrapedResult.emojiStore)) // ugh, icb explaining this - think about it (translates index as it can be called multiple times)
 	for i, el := range scrapedResult.emojiStore {
 		old[i] = len(el.emojis.list)
@@ -115,61 +197,73 @@ func (scrapedResult *ScrapedResult) AddFromDOM(dom *goquery.Document) (err error
 		emojis := s.Find(".andr")
 		name := s.Find(".name").Text()
 
-		// need to handle cases because their formatting isn't scraper-friendly
-		if emojis.Length() == len(scrapedResult.emojiStore) {
+		fail := func(kind ScrapeErrorKind, brand string, err error) {
+			scraperError = &ScrapeError{kind: kind, row: emojiIndex, name: name, brand: CanonicalBrand(brand), err: err}
+		}
 
-			row := s.Find(".andr")
-			if row.Length() != len(relativeTranslation) {
-				scraperError = fmt.Errorf("malformed row, unexpected number of emojis")
+		info := headings[emojiIndex]
+		if emojis.Length() > 0 {
+			var err error
+			if info.code, err = ParseCodepoints(s.Find(".code").Text()); err != nil {
+				fail(ErrCodepoints, "", err)
 				return
 			}
+		}
 
-			row.EachWithBreak(func(i int, s *goquery.Selection) bool {
+		// need to handle cases because their formatting isn't scraper-friendly
+		if emojis.Length() == len(brandNames) {
+
+			emojis.EachWithBreak(func(i int, s *goquery.Selection) bool {
+				if relativeTranslation[i] == -1 {
+					return true
+				}
 
 				img := s.Find("img")
 				if img.Length() != 1 { // be very strict about this
 					return true
 				}
 
-				if scraperError = scrapedResult.Store(img, name, emojiIndex+old[relativeTranslation[i]], relativeTranslation[i]); scraperError != nil {
+				if err := scrapedResult.Store(img, name, info, emojiIndex+old[relativeTranslation[i]], relativeTranslation[i]); err != nil {
+					fail(ErrImage, brandNames[i], err)
 					return false
 				}
 
-				scrapedResult.total++
+				atomic.AddInt64(&scrapedResult.total, 1)
 				return true
 			}) // normal emoji row
 
 		} else if emojis.Length() == 1 { // this is for new, experimental emojis
 
 			s.Find("img").EachWithBreak(func(i int, s *goquery.Selection) bool {
-				scraperError = fmt.Errorf("entry in experimental row didn't have a title")
 
 				title, state := s.Attr("title")
-				if !state {
-					return false
-				}
-
 				titleMatch := experimentalReg.FindStringSubmatch(title)
-				if len(titleMatch) == 0 {
+				if !state || len(titleMatch) == 0 {
+					fail(ErrTitle, "", fmt.Errorf("entry in experimental row didn't have a title"))
 					return false
 				}
-				if titleMatch[1] == "Sample" {
-					scraperError = nil // weird loop machanism, not my fault - blame jquery standards
-					return true        // we don't care about this
+
+				if titleMatch[1] == "Sample" || !scrapedResult.filter.Allows(titleMatch[1]) {
+					return true // we don't care about this
 				}
 
 				index := scrapedResult.getIndex(titleMatch[1])
 				if index <= -1 {
+					fail(ErrUnknownBrand, titleMatch[1], fmt.Errorf("brand isn't in the table header"))
 					return false
 				}
 
-				if scraperError = scrapedResult.Store(s, name, emojiIndex+old[relativeTranslation[index]], relativeTranslation[index]); scraperError != nil {
+				if err := scrapedResult.Store(s, name, info, emojiIndex+old[index], index); err != nil {
+					fail(ErrImage, titleMatch[1], err)
 					return false
 				}
 
-				scrapedResult.total++
+				atomic.AddInt64(&scrapedResult.total, 1)
 				return true
 			})
+
+		} else if emojis.Length() > 0 { // header rows have none, anything else means the markup changed
+			fail(ErrMalformedRow, "", fmt.Errorf("%d emojis in a row for %d brands", emojis.Length(), len(brandNames)))
 		}
 	}
 
@@ -180,6 +274,8 @@ func (scrapedResult *ScrapedResult) AddFromDOM(dom *goquery.Document) (err error
 
 	var errorMarshal sync.WaitGroup
 
+	before := len(scrapedResult.errors)
+
 	errorMarshal.Add(1)
 	go func() {
 		for scraperTotem.count > 0 {
@@ -196,42 +292,247 @@ func (scrapedResult *ScrapedResult) AddFromDOM(dom *goquery.Document) (err error
 	}()
 	errorMarshal.Wait()
 
-	for _, erro := range scrapedResult.errors {
-		fmt.Printf("[WARNING] scraper error: %s\t", erro)
+	for _, erro := range scrapedResult.errors[before:] {
+		fmt.Printf("\n[warning] scraper error: %s", erro)
 	}
 
 	return err // notice that this doesn't include errors from the scraping routine - that's up to the user to decide to look at
 }
 
-func Scrape(IncludeModifiers bool, imageSettings Settings) (result ScrapedResult, err error) {
+/*
+emoji-test.txt has the status and emoji version of every emoji, the charts don't
+lines look like - 1F600 ; fully-qualified # 😀 E1.0 grinning face
+with "# group: ..." and "# subgroup: ..." lines above them
+*/
+func ParseEmojiTest(reader io.Reader) (map[string]EmojiInfo, error) {
 
-	result.imageSettings = imageSettings
+	infos := make(map[string]EmojiInfo)
+	var group, subgroup string
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "# group:") {
+			group = strings.TrimSpace(strings.TrimPrefix(line, "# group:"))
+			continue
+		}
+		if strings.HasPrefix(line, "# subgroup:") {
+			subgroup = strings.TrimSpace(strings.TrimPrefix(line, "# subgroup:"))
+			continue
+		}
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, ";", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		code, err := ParseCodepoints(fields[0])
+		if err != nil {
+			return nil, err
+		}
+
+		info := EmojiInfo{code: code, group: group, subgroup: subgroup}
 
-	website := "https://unicode.org/emoji/charts"
-	modifiers := "full-emoji-modifiers.html"
+		statusComment := strings.SplitN(fields[1], "#", 2)
+		info.status = strings.TrimSpace(statusComment[0])
+		if len(statusComment) == 2 {
+			if comment := strings.Fields(statusComment[1]); len(comment) > 1 && strings.HasPrefix(comment[1], "E") {
+				info.version = comment[1]
+			}
+		}
 
-	urls := []string{"full-emoji-list.html"}
-	if IncludeModifiers {
-		urls = append(urls, modifiers)
+		infos[code] = info
 	}
 
-	for _, page := range urls {
+	return infos, scanner.Err()
+}
+
+// fills in whatever the charts didn't have
+func (emojis EmojiKeg) ApplyEmojiTest(infos map[string]EmojiInfo) {
+	for _, brand := range emojis {
+		for _, emoji := range brand.emojis.list {
+			if emoji == nil {
+				continue // rows without an image for this brand, stripped later
+			}
+
+			info, exists := infos[emoji.info.code]
+			if !exists {
+				info, exists = infos[strings.ReplaceAll(emoji.info.code, "\uFE0F", "")] // charts and test file don't always agree on variation selectors
+			}
+			if !exists {
+				continue
+			}
 
-		var resp *http.Response
-		// var resp io.Reader
+			emoji.info.version = info.version
+			emoji.info.status = info.status
+			if len(emoji.info.group) == 0 {
+				emoji.info.group, emoji.info.subgroup = info.group, info.subgroup
+			}
+		}
+	}
+}
+
+const defaultChartsURL = "https://unicode.org/emoji/charts"
+const defaultEmojiTestURL = "https://unicode.org/Public/emoji/latest/emoji-test.txt"
+
+const chartList = "full-emoji-list.html"
+const chartModifiers = "full-emoji-modifiers.html"
+const emojiTestFile = "emoji-test.txt"
+
+/*
+where the html source gets its pages from
+- chartsURL: base url the chart pages are under, point it at e.g. https://unicode.org/emoji/charts-15.0 to pin a release
+- files: saved chart pages (or folders of them) to use instead of downloading anything
+- emojiTest: url or path of emoji-test.txt, empty => a saved one next to the pages or the one matching chartsURL's release
+- timeout, retries, cacheDir: see Fetcher
+- strict: fail the scrape if more than maxErrors rows went wrong rather than carrying on without them
+*/
+type ScrapeSource struct {
+	chartsURL string
+	files     []string
+	emojiTest string
+	modifiers bool
+
+	strict    bool
+	maxErrors int
+
+	timeout  time.Duration
+	retries  int
+	cacheDir string
+}
+
+func DefaultScrapeSource() ScrapeSource {
+	return ScrapeSource{
+		chartsURL: defaultChartsURL,
+		modifiers: true,
+		timeout:   defaultTimeout,
+		retries:   defaultRetries,
+		cacheDir:  DefaultCacheDir(),
+	}
+}
+
+// the chart pages to read in order, folders are expanded to the pages saved inside them
+func (source ScrapeSource) Pages() ([]string, error) {
+
+	if len(source.files) == 0 {
+		pages := []string{fmt.Sprintf("%s/%s", strings.TrimSuffix(source.chartsURL, "/"), chartList)}
+		if source.modifiers {
+			pages = append(pages, fmt.Sprintf("%s/%s", strings.TrimSuffix(source.chartsURL, "/"), chartModifiers))
+		}
+		return pages, nil
+	}
+
+	var pages []string
+	for _, file := range source.files {
+		folder, err := IsDir(file)
+		if err != nil {
+			return nil, err
+		}
+		if !folder {
+			pages = append(pages, file) // asked for by name so it's used whatever it's called
+			continue
+		}
+
+		list := filepath.Join(file, chartList)
+		if _, err := os.Stat(list); err != nil {
+			return nil, fmt.Errorf("%s doesn't have a saved %s", file, chartList)
+		}
+		pages = append(pages, list)
+
+		if source.modifiers {
+			if modifiers := filepath.Join(file, chartModifiers); fileExists(modifiers) {
+				pages = append(pages, modifiers)
+			} else {
+				fmt.Printf("[warning] %s doesn't have a saved %s, skipping modifiers\n", file, chartModifiers)
+			}
+		}
+	}
+	return pages, nil
+}
+
+/*
+where to get emoji-test.txt from, local pages look for a saved copy next to them and a pinned release (charts-15.0) uses that release's
+empty => local pages without one (they're meant to work offline so unicode.org is only asked if test= says so), or a charts url whose release can't be told
+*/
+func (source ScrapeSource) EmojiTest() string {
+	if len(source.emojiTest) > 0 {
+		return source.emojiTest
+	}
+
+	for _, file := range source.files {
+		if folder, err := IsDir(file); err == nil && !folder {
+			file = filepath.Dir(file)
+		}
+		if test := filepath.Join(file, emojiTestFile); fileExists(test) {
+			return test
+		}
+	}
+
+	if len(source.files) > 0 {
+		return ""
+	}
+
+	if chartsURL := strings.TrimSuffix(source.chartsURL, "/"); chartsURL == defaultChartsURL {
+		return defaultEmojiTestURL
+	} else if release := chartsReleaseReg.FindStringSubmatch(chartsURL); len(release) > 0 {
+		return fmt.Sprintf("%s/Public/emoji/%s/%s", release[1], release[2], emojiTestFile)
+	}
+	return "" // latest could be a different release to the pages
+}
+
+func fileExists(fileName string) bool {
+	_, err := os.Stat(fileName)
+	return err == nil
+}
+
+// url or local path
+func (source ScrapeSource) open(location string, fetcher *Fetcher) (io.ReadCloser, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return os.Open(location)
+	}
+	return fetcher.Fetch(location)
+}
+
+func (source ScrapeSource) fetchEmojiTest(location string, fetcher *Fetcher) (map[string]EmojiInfo, error) {
+	body, err := source.open(location, fetcher)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return ParseEmojiTest(body)
+}
+
+func Scrape(source ScrapeSource, filter *BrandFilter, imageSettings Settings) (result ScrapedResult, err error) {
+
+	result.imageSettings = imageSettings
+	result.filter = filter
+
+	pages, err := source.Pages()
+	if err != nil {
+		return result, err
+	}
+
+	fetcher := NewFetcher(source.timeout, source.retries, source.cacheDir)
+
+	for _, page := range pages {
+
+		var body io.ReadCloser
 		var doc *goquery.Document
 
-		url := fmt.Sprintf("%s/%s", website, page)
-		fmt.Printf("Making emojikeg from %s ", url)
+		fmt.Printf("Making emojikeg from %s ", page)
 
-		resp, err = http.Get(url)
-		// resp, err = os.Open("tests/" + page)
+		body, err = source.open(page, fetcher)
 		if err != nil {
 			break
 		}
-		defer resp.Body.Close()
+		defer body.Close()
 
-		doc, err = goquery.NewDocumentFromReader(resp.Body) // this is so cool! it reads it as it downloads
+		doc, err = goquery.NewDocumentFromReader(body) // this is so cool! it reads it as it downloads
 		if err != nil {
 			break
 		}
@@ -240,6 +541,31 @@ func Scrape(IncludeModifiers bool, imageSettings Settings) (result ScrapedResult
 		fmt.Printf("\n")
 	}
 
+	if len(result.errors) > 0 {
+		fmt.Printf("[warning] %d scraper errors - %s\n", len(result.errors), result.Summary())
+
+		if err == nil && source.strict && len(result.errors) > source.maxErrors {
+			err = fmt.Errorf("%d scraper errors, only %d allowed (%s) - has unicode.org's markup changed?", len(result.errors), source.maxErrors, result.Summary())
+		}
+	}
+
+	if testLocation := source.EmojiTest(); err == nil && len(testLocation) == 0 {
+		where := "next to the saved pages"
+		if len(source.files) == 0 {
+			where = "for " + source.chartsURL
+		}
+		fmt.Printf("[warning] no %s %s, carrying on without emoji versions (test= says where one is)\n", emojiTestFile, where)
+
+	} else if err == nil {
+		fmt.Printf("Fetching emoji versions from %s\n", testLocation)
+
+		if infos, testErr := source.fetchEmojiTest(testLocation, fetcher); testErr != nil {
+			fmt.Printf("[warning] couldn't get emoji versions, carrying on without them: %s\n", testErr)
+		} else {
+			result.emojiStore.ApplyEmojiTest(infos)
+		}
+	}
+
 	result.emojiStore.StripEmptyEmojis()
 	result.emojiStore.PreetifyBrandNames()
 
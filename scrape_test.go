@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const chartsFixture = "testdata/charts"
+
+// a page from the fixture, parsed into result
+func addPage(t *testing.T, result *ScrapedResult, page string) {
+	t.Helper()
+
+	file, err := os.Open(filepath.Join(chartsFixture, page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	doc, err := goquery.NewDocumentFromReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := result.AddFromDOM(doc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// the brand's emojis without the gaps left by header rows and missing images
+func scraped(brand *Brand) []*Emoji {
+	var emojis []*Emoji
+	for _, emoji := range brand.emojis.list {
+		if emoji != nil {
+			emojis = append(emojis, emoji)
+		}
+	}
+	return emojis
+}
+
+func brandNames(emojis EmojiKeg) []string {
+	var names []string
+	for _, brand := range emojis {
+		names = append(names, brand.name)
+	}
+	return names
+}
+
+func TestAddFromDOM(t *testing.T) {
+	result := &ScrapedResult{imageSettings: Settings{imageScale: 1}}
+	addPage(t, result, chartList)
+
+	if len(result.errors) > 0 {
+		t.Fatalf("unexpected scraper errors: %v", result.errors)
+	}
+	// the header's Browser cell runs into Appl, PreetifyBrandNames sorts that out later
+	if got := strings.Join(brandNames(result.emojiStore), ","); got != "BrowserAppl,Goog,Sams" {
+		t.Fatalf("got brands %s, want BrowserAppl,Goog,Sams", got)
+	}
+
+	apple := scraped(result.emojiStore[0])
+	want := []struct{ name, code, group, subgroup string }{
+		{"grinning face", "\U0001F600", "Smileys & Emotion", "face-smiling"},
+		{"grinning face with big eyes", "\U0001F603", "Smileys & Emotion", "face-smiling"},
+		{"waving hand", "\U0001F44B", "People & Body", "hand-fingers-open"},
+		{"couple", "\U0001F468\u200d\U0001F469", "People & Body", "family"},
+	}
+	if len(apple) != len(want) {
+		t.Fatalf("got %d Apple emojis, want %d", len(apple), len(want))
+	}
+	for i, el := range want {
+		emoji := apple[i]
+		if emoji.name != el.name || emoji.info.code != el.code || emoji.info.group != el.group || emoji.info.subgroup != el.subgroup {
+			t.Errorf("emoji %d is %q %q %q/%q, want %q %q %q/%q", i, emoji.name, emoji.info.code, emoji.info.group, emoji.info.subgroup, el.name, el.code, el.group, el.subgroup)
+		}
+	}
+
+	// Samsung's waving hand is a dash in the fixture, it's skipped rather than being an error
+	if got := len(scraped(result.emojiStore[2])); got != 3 {
+		t.Errorf("got %d Samsung emojis, want 3", got)
+	}
+}
+
+func TestAddFromDOMModifiers(t *testing.T) {
+	result := &ScrapedResult{imageSettings: Settings{imageScale: 1}}
+	addPage(t, result, chartList)
+	addPage(t, result, chartModifiers)
+
+	if len(result.emojiStore) != 3 {
+		t.Fatalf("second page added brands, got %v", brandNames(result.emojiStore))
+	}
+
+	apple := scraped(result.emojiStore[0])
+	if len(apple) != 6 {
+		t.Fatalf("got %d Apple emojis, want 6", len(apple))
+	}
+	if apple[4].name != "waving hand: light skin tone" || apple[5].name != "waving hand: dark skin tone" {
+		t.Errorf("modifiers came after the list out of order: %q, %q", apple[4].name, apple[5].name)
+	}
+
+	store := &result.emojiStore[0].emojis
+	store.list = apple
+	store.Reindex()
+	if variants := store.Variants("\U0001F44B"); len(variants) != 3 {
+		t.Errorf("got %d waving hand variants, want 3", len(variants))
+	}
+}
+
+func TestAddFromDOMFilter(t *testing.T) {
+	result := &ScrapedResult{imageSettings: Settings{imageScale: 1}, filter: &BrandFilter{include: []string{"Google"}}}
+	addPage(t, result, chartList)
+
+	if got := strings.Join(brandNames(result.emojiStore), ","); got != "Goog" {
+		t.Fatalf("got brands %s, want only Goog", got)
+	}
+	if got := len(scraped(result.emojiStore[0])); got != 4 {
+		t.Errorf("got %d Google emojis, want 4", got)
+	}
+}
+
+func TestAddFromDOMErrors(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(chartsFixture, chartList))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a codepoint that isn't hex, an image that isn't base64 and a row missing a brand's cell
+	page := strings.Replace(string(data), "U+1F603", "U+NOPE", 1)
+	page = strings.Replace(page, "base64,iVBORw0KGgo", "base64,!!!!", 1)
+	page = strings.Replace(page, "<td class='andr'>—</td>", "", 1)
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := &ScrapedResult{imageSettings: Settings{imageScale: 1}}
+	if err := result.AddFromDOM(doc); err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := make(map[ScrapeErrorKind]bool)
+	for _, err := range result.errors {
+		var scrapeError *ScrapeError
+		if !errors.As(err, &scrapeError) {
+			t.Fatalf("%v isn't a *ScrapeError", err)
+		}
+		kinds[scrapeError.kind] = true
+	}
+	if len(result.errors) != 3 || !kinds[ErrCodepoints] || !kinds[ErrImage] || !kinds[ErrMalformedRow] {
+		t.Fatalf("got errors %v, want one codepoints, one image and one malformed row", result.errors)
+	}
+
+	summary := result.Summary()
+	for _, want := range []string{"codepoints: 1", "image: 1", "malformed row: 1"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("summary %q doesn't have %q", summary, want)
+		}
+	}
+}
+
+func TestEmojiTestLocation(t *testing.T) {
+	dir := t.TempDir()
+	for _, page := range []string{chartList, chartModifiers} {
+		data, err := os.ReadFile(filepath.Join(chartsFixture, page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, page), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	source := DefaultScrapeSource()
+	if got := source.EmojiTest(); got != defaultEmojiTestURL {
+		t.Errorf("downloading the charts got %q, want %q", got, defaultEmojiTestURL)
+	}
+
+	// a pinned release gets that release's, not whatever latest is now
+	source.chartsURL = "https://unicode.org/emoji/charts-15.0/"
+	if got, want := source.EmojiTest(), "https://unicode.org/Public/emoji/15.0/emoji-test.txt"; got != want {
+		t.Errorf("charts-15.0 got %q, want %q", got, want)
+	}
+	source.chartsURL = "https://mirror.example.com/charts"
+	if got := source.EmojiTest(); got != "" {
+		t.Errorf("charts url without a release got %q, want nothing", got)
+	}
+
+	source.files = []string{chartsFixture}
+	if got, want := source.EmojiTest(), filepath.Join(chartsFixture, emojiTestFile); got != want {
+		t.Errorf("saved pages with a saved emoji-test.txt got %q, want %q", got, want)
+	}
+
+	// offline pages shouldn't go looking on unicode.org
+	source.files = []string{filepath.Join(dir, chartList)}
+	if got := source.EmojiTest(); got != "" {
+		t.Errorf("saved pages without emoji-test.txt got %q, want nothing", got)
+	}
+
+	source.emojiTest = "https://example.com/emoji-test.txt"
+	if got := source.EmojiTest(); got != source.emojiTest {
+		t.Errorf("test= got %q, want %q", got, source.emojiTest)
+	}
+}
+
+func TestScrapeOffline(t *testing.T) {
+	source := DefaultScrapeSource()
+	source.files = []string{chartsFixture}
+	source.chartsURL = "http://127.0.0.1:0" // anything that tries to download fails straight away
+	source.cacheDir = ""
+	source.retries = 0
+
+	result, err := Scrape(source, nil, Settings{imageScale: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(brandNames(result.emojiStore), ","); got != "Apple,Google,Samsung" {
+		t.Fatalf("got brands %s, want Apple,Google,Samsung", got)
+	}
+
+	var waving *Emoji
+	for _, emoji := range result.emojiStore[0].emojis.list {
+		if emoji.name == "waving hand: light skin tone" {
+			waving = emoji
+		}
+	}
+	if waving == nil {
+		t.Fatal("modifiers page wasn't scraped")
+	}
+	if waving.info.version != "E1.0" || waving.info.status != "fully-qualified" {
+		t.Errorf("emoji-test.txt wasn't applied, got version %q status %q", waving.info.version, waving.info.status)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fitzpatrick modifiers, ToneNone is an emoji without one (the yellow default)
+type SkinTone int
+
+const (
+	ToneNone SkinTone = iota
+	ToneLight
+	ToneMediumLight
+	ToneMedium
+	ToneMediumDark
+	ToneDark
+)
+
+// U+1F3FB is light, each step after it is one darker
+const firstToneModifier = '\U0001F3FB'
+
+var toneNames = []string{"base", "light", "medium-light", "medium", "medium-dark", "dark"}
+
+func ParseTone(name string) (SkinTone, error) {
+	for i, el := range toneNames {
+		if strings.EqualFold(el, name) {
+			return SkinTone(i), nil
+		}
+	}
+	return ToneNone, fmt.Errorf("unknown skin tone %s (should be one of %s)", name, strings.Join(toneNames, "/"))
+}
+
+func (tone SkinTone) String() string {
+	return toneNames[tone]
+}
+
+func toneOf(r rune) (SkinTone, bool) {
+	if r < firstToneModifier || r > firstToneModifier+4 {
+		return ToneNone, false
+	}
+	return SkinTone(r-firstToneModifier) + ToneLight, true
+}
+
+/*
+every tone in the emoji, in order - more than one for families, couples... (kiss: light skin tone, dark skin tone)
+worked out from its characters, or its CLDR name (": light skin tone") when it doesn't know them
+*/
+func (emoji *Emoji) Tones() []SkinTone {
+	var tones []SkinTone
+
+	if len(emoji.info.code) > 0 {
+		for _, r := range emoji.info.code {
+			if tone, exists := toneOf(r); exists {
+				tones = append(tones, tone)
+			}
+		}
+		return tones
+	}
+
+	_, suffix, found := strings.Cut(emoji.name, ": ")
+	if !found {
+		return nil
+	}
+	for _, el := range strings.Split(suffix, ", ") {
+		if name := strings.TrimSuffix(el, " skin tone"); name != el {
+			if tone, err := ParseTone(strings.ReplaceAll(name, " ", "-")); err == nil {
+				tones = append(tones, tone)
+			}
+		}
+	}
+	return tones
+}
+
+/*
+whether Tones can be trusted - emojis without codepoints (the internal cartridge, old cartridges named 0, 1...) only have their name to go on
+a name without ": ... skin tone" could just as well be a toned emoji as a base one
+*/
+func (emoji *Emoji) KnowsTone() bool {
+	return len(emoji.info.code) > 0 || len(emoji.Tones()) > 0
+}
+
+// how many of the brand's emojis have a tone that can't be worked out
+func (brand *Brand) UnknownTones() int {
+	var unknown int
+	for _, emoji := range brand.emojis.list {
+		if !emoji.KnowsTone() {
+			unknown++
+		}
+	}
+	return unknown
+}
+
+func firstTone(emoji *Emoji) SkinTone {
+	if tones := emoji.Tones(); len(tones) > 0 {
+		return tones[0]
+	}
+	return ToneNone
+}
+
+// the characters of the emoji without its skin tones (or variation selectors, ✌️ vs ✌🏻) so every variant shares the same one
+func BaseCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if _, exists := toneOf(r); exists || r == '\uFE0F' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+// which tones an emojify is allowed to use, nil => all of them
+type ToneSelection map[SkinTone]bool
+
+// all, base, or tones seperated by commas (base,medium)
+func ParseToneSelection(value string) (ToneSelection, error) {
+	if value == "all" {
+		return nil, nil
+	}
+
+	selection := make(ToneSelection)
+	for _, el := range strings.Split(value, ",") {
+		tone, err := ParseTone(el)
+		if err != nil {
+			return nil, err
+		}
+		selection[tone] = true
+	}
+	return selection, nil
+}
+
+// every tone in the emoji has to be selected, emojis without one count as base
+func (selection ToneSelection) Allows(emoji *Emoji) bool {
+	if selection == nil {
+		return true
+	}
+
+	tones := emoji.Tones()
+	if len(tones) == 0 {
+		return selection[ToneNone]
+	}
+	for _, tone := range tones {
+		if !selection[tone] {
+			return false
+		}
+	}
+	return true
+}
+
+// a copy of the brand with only the emojis in the selection, it's an error if some of them can't be told apart (they'd sneak through as base)
+func (brand *Brand) WithTones(selection ToneSelection) (*Brand, error) {
+	if selection == nil {
+		return brand, nil
+	}
+
+	if unknown := brand.UnknownTones(); unknown > 0 {
+		return nil, fmt.Errorf("can't select skin tones from %s, %d of its %d emojis have no codepoints to tell their tone by - scrape it again or use a cartridge with a manifest (or tones:all)", brand.name, unknown, len(brand.emojis.list))
+	}
+
+	selected := InitBrand(brand.name, brand.emojis.settings)
+	selected.emojis.weights = brand.emojis.weights
+
+	for _, emoji := range brand.emojis.list {
+		if selection.Allows(emoji) {
+			selected.emojis.list = append(selected.emojis.list, emoji)
+			selected.emojis.index(emoji)
+		}
+	}
+
+	if len(selected.emojis.list) == 0 {
+		return nil, fmt.Errorf("%s has no emojis with the selected skin tones", brand.name)
+	}
+	return selected, nil
+}
+
+// the emoji and all its skin tone variants (base first, then lightest to darkest), the code can be any one of them
+func (store *EmojiStore) Variants(code string) []*Emoji {
+	return store.variants[BaseCode(code)]
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func toneBrand(names []string, codes []string) *Brand {
+	brand := InitBrand("Tones", Settings{imageScale: 1})
+	for i, name := range names {
+		emoji := brand.emojis.Add(name, GetTransparent(color.RGBA{A: 255}, image.Rect(0, 0, 1, 1)), i)
+		emoji.info.code = codes[i]
+	}
+	brand.emojis.Reindex()
+	return brand
+}
+
+func TestWithTones(t *testing.T) {
+	selection, err := ParseToneSelection("base")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	brand := toneBrand([]string{"waving hand", "waving hand: dark skin tone"}, []string{"\U0001F44B", "\U0001F44B\U0001F3FF"})
+	selected, err := brand.WithTones(selection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected.emojis.list) != 1 || selected.emojis.list[0].name != "waving hand" {
+		t.Errorf("tones:base kept %d emojis", len(selected.emojis.list))
+	}
+
+	// numbered like an old cartridge, there's no telling which of these are toned
+	legacy := toneBrand([]string{"0", "1"}, []string{"", ""})
+	if _, err := legacy.WithTones(selection); err == nil {
+		t.Error("selecting tones from emojis without codepoints should fail")
+	}
+	if selected, err := legacy.WithTones(nil); err != nil || selected != legacy {
+		t.Errorf("tones:all should leave the brand alone, got %v", err)
+	}
+}